@@ -0,0 +1,67 @@
+// Copyright 2013, zhangpeihao All rights reserved.
+
+package amf
+
+import (
+	"reflect"
+	"sync"
+)
+
+var classAliases struct {
+	sync.RWMutex
+	types   map[string]reflect.Type
+	aliases map[reflect.Type]string
+}
+
+// RegisterClassAlias maps the ActionScript class alias to the struct type
+// of v, like registerClassAlias in ActionScript. Typed objects of that
+// class decode to a pointer to a new value of the type, and values of the
+// type are encoded as typed objects of that class.
+//
+// A type whose pointer implements Externalizable need not be a struct, its
+// AMF3 objects are read and written by ReadExternal and WriteExternal.
+func RegisterClassAlias(alias string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || (t.Kind() != reflect.Struct && !reflect.PtrTo(t).Implements(externalizableType)) {
+		panic("amf: RegisterClassAlias of non-struct type")
+	}
+	if alias == "" {
+		panic("amf: RegisterClassAlias with empty alias")
+	}
+	classAliases.Lock()
+	defer classAliases.Unlock()
+	if classAliases.types == nil {
+		classAliases.types = make(map[string]reflect.Type)
+		classAliases.aliases = make(map[reflect.Type]string)
+	}
+	classAliases.types[alias] = t
+	classAliases.aliases[t] = alias
+}
+
+// aliasType returns the type registered for alias, or nil.
+func aliasType(alias string) reflect.Type {
+	classAliases.RLock()
+	defer classAliases.RUnlock()
+	return classAliases.types[alias]
+}
+
+// typeAlias returns the alias registered for type t, or "".
+func typeAlias(t reflect.Type) string {
+	classAliases.RLock()
+	defer classAliases.RUnlock()
+	return classAliases.aliases[t]
+}
+
+// newAliasValue makes a value of the type registered for alias from the
+// properties of a decoded typed object. ptr is the pointer returned by
+// reflect.New for that type.
+func newAliasValue(ptr reflect.Value, props Object, version uint) error {
+	d := &decodeState{
+		version: version,
+		seen:    make(map[objectKey]reflect.Value),
+	}
+	return d.assignObject(props, nil, ptr.Elem())
+}
@@ -0,0 +1,129 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type aliasUser struct {
+	Name   string     `amf:"name"`
+	Age    int        `amf:"age"`
+	Friend *aliasUser `amf:"friend,omitempty"`
+}
+
+func init() {
+	RegisterClassAlias("com.example.AliasUser", aliasUser{})
+	RegisterClassAlias("com.example.Point", testPoint{})
+}
+
+// testPoint writes X and Y as raw shorts and Label as an AMF3 value.
+type testPoint struct {
+	X, Y  int16
+	Label string
+}
+
+func (p *testPoint) ReadExternal(dec *Decoder) error {
+	if err := binary.Read(dec, binary.BigEndian, &p.X); err != nil {
+		return err
+	}
+	if err := binary.Read(dec, binary.BigEndian, &p.Y); err != nil {
+		return err
+	}
+	return dec.Decode(&p.Label)
+}
+
+func (p *testPoint) WriteExternal(enc *Encoder) error {
+	if err := binary.Write(enc, binary.BigEndian, p.X); err != nil {
+		return err
+	}
+	if err := binary.Write(enc, binary.BigEndian, p.Y); err != nil {
+		return err
+	}
+	return enc.Encode(p.Label)
+}
+
+func TestClassAliasAMF3(t *testing.T) {
+	in := &aliasUser{Name: "a", Age: 1}
+	data, err := Marshal(in, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	expect := []byte{0x0A, 0x23, // sealed, 2 members
+		0x2B, 'c', 'o', 'm', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'A', 'l', 'i', 'a', 's', 'U', 's', 'e', 'r',
+		0x09, 'n', 'a', 'm', 'e', 0x07, 'a', 'g', 'e',
+		0x06, 0x03, 'a',
+		0x04, 0x01,
+	}
+	if !bytes.Equal(expect, data) {
+		t.Errorf("Marshal expect % x got % x", expect, data)
+	}
+
+	value, err := AMF3_ReadValue(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	out, ok := value.(*aliasUser)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect *aliasUser", value)
+	}
+	if *out != *in {
+		t.Errorf("AMF3_ReadValue return %+v", out)
+	}
+}
+
+func TestClassAliasAMF0(t *testing.T) {
+	in := &aliasUser{Name: "a", Age: 1}
+	in.Friend = in
+	buf := new(bytes.Buffer)
+	_, err := WriteValueWithReferences(buf, in)
+	if err != nil {
+		t.Fatalf("WriteValueWithReferences error: %s", err)
+	}
+	if buf.Bytes()[0] != AMF0_TYPED_OBJECT_MARKER {
+		t.Errorf("WriteValueWithReferences marker: 0x%02x", buf.Bytes()[0])
+	}
+	var out *aliasUser
+	if err = Unmarshal(buf.Bytes(), &out, AMF0); err != nil {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+	if out.Name != "a" || out.Age != 1 || out.Friend != out {
+		t.Errorf("Unmarshal return %+v", out)
+	}
+}
+
+func TestExternalizable(t *testing.T) {
+	p := &testPoint{X: 1, Y: -1, Label: "p"}
+	data, err := Marshal([]interface{}{p, p, "p"}, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	expect := []byte{0x09, 0x07, 0x01, // array of 3
+		0x0A, 0x07, // externalizable traits
+		0x23, 'c', 'o', 'm', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'P', 'o', 'i', 'n', 't',
+		0x00, 0x01, 0xFF, 0xFF, 0x06, 0x03, 'p',
+		0x0A, 0x02, // object reference 1
+		0x06, 0x02, // string reference 1
+	}
+	if !bytes.Equal(expect, data) {
+		t.Errorf("Marshal expect % x got % x", expect, data)
+	}
+
+	value, err := AMF3_ReadValue(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	arr, ok := value.([]interface{})
+	if !ok || len(arr) != 3 {
+		t.Fatalf("AMF3_ReadValue return %#v", value)
+	}
+	out, ok := arr[0].(*testPoint)
+	if !ok || *out != *p || arr[1] != arr[0] || arr[2] != "p" {
+		t.Errorf("AMF3_ReadValue return %#v", arr)
+	}
+
+	unknown := []byte{0x0A, 0x07, 0x03, 'X', 0x00}
+	if _, err = AMF3_ReadValue(bytes.NewReader(unknown)); err == nil {
+		t.Errorf("AMF3_ReadValue of unknown externalizable class expect error")
+	}
+}
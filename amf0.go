@@ -6,12 +6,76 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"sort"
-	"strings"
 	"time"
 )
 
+// AMF0 reference table.
+//
+// Anonymous objects, ECMA arrays and strict arrays take an index in the
+// reference table of a message, a later occurrence of the same object may be
+// sent as a reference to that index. An amf0Context holds the table for the
+// duration of one message; every exported function starts from a fresh one,
+// an Encoder or Decoder keeps one until Reset.
+type amf0Context struct {
+	// Options
+	references bool // send repeated pointers and maps as references
+	timeZone   TimeZoneMode
+	avmPlus    bool // send complex values in AMF3
+	strict     bool // send slices and arrays as strict arrays
+	ordered    bool // read anonymous objects as *OrderedObject
+
+	// Read side
+	objects []interface{}
+
+	// Write side
+	objectRefs  map[objectKey]int
+	objectCount int
+	writing     map[writingKey]bool // without references, to find cycles
+
+	// Reference tables of the values sent in AMF3, shared by all the
+	// avmplus switches of the message
+	amf3 *amf3Context
+}
+
+func newAMF0Context() *amf0Context {
+	return &amf0Context{
+		objectRefs: make(map[objectKey]int),
+		writing:    make(map[writingKey]bool),
+		amf3:       newAMF3Context(),
+	}
+}
+
+// Identity of a pointer, map or slice that is being written. The length
+// tells a slice from a shorter one of the same array.
+type writingKey struct {
+	objectKey
+	n int
+}
+
+// Sizes of the write side tables, to drop what a failed value added.
+type amf0Mark struct {
+	objects int
+	amf3    amf3Mark
+}
+
+func (ctx *amf0Context) mark() amf0Mark {
+	return amf0Mark{ctx.objectCount, ctx.amf3.mark()}
+}
+
+// rollback drops the write side table entries added since m.
+func (ctx *amf0Context) rollback(m amf0Mark) {
+	for key, index := range ctx.objectRefs {
+		if index >= m.objects {
+			delete(ctx.objectRefs, key)
+		}
+	}
+	ctx.objectCount = m.objects
+	ctx.amf3.rollback(m.amf3)
+}
+
 //-----------------------------------------------------------------------
 // AMF0 Write functions
 
@@ -109,24 +173,80 @@ func WriteUndefined(w Writer) (n int, err error) {
 }
 
 func WriteEcmaArray(w Writer, arr []interface{}) (n int, err error) {
+	return newAMF0Context().writeEcmaArray(w, reflect.ValueOf(arr))
+}
+
+func (ctx *amf0Context) writeEcmaArray(w Writer, v reflect.Value) (n int, err error) {
+	if index, ok := ctx.lookupObject(v); ok {
+		return WriteReference(w, uint16(index))
+	}
 	n, err = WriteMarker(w, AMF0_ECMA_ARRAY_MARKER)
 	if err != nil {
 		return
 	}
-	length := int32(len(arr))
+	length := int32(v.Len())
 	err = binary.Write(w, binary.BigEndian, &length)
 	if err != nil {
 		return
 	}
 	n += 4
 	m := 0
-	for index, value := range arr {
+	for index := int32(0); index < length; index++ {
 		m, err = WriteObjectName(w, fmt.Sprintf("%d", index))
 		if err != nil {
 			return
 		}
 		n += m
-		m, err = WriteValue(w, value)
+		m, err = ctx.writeReflectValue(w, v.Index(int(index)))
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	m, err = WriteObjectEndMarker(w)
+	return n + m, err
+}
+
+// writeEcmaArrayValue writes an ECMAArray with its count, the properties
+// in the order of Keys and then any others of Object by name.
+func (ctx *amf0Context) writeEcmaArrayValue(w Writer, v reflect.Value) (n int, err error) {
+	if index, ok := ctx.lookupObject(v); ok {
+		return WriteReference(w, uint16(index))
+	}
+	arr := v.Interface().(ECMAArray)
+	n, err = WriteMarker(w, AMF0_ECMA_ARRAY_MARKER)
+	if err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, arr.Count)
+	if err != nil {
+		return
+	}
+	n += 4
+	keys := make([]string, 0, len(arr.Object))
+	listed := make(map[string]bool, len(arr.Keys))
+	for _, key := range arr.Keys {
+		if _, ok := arr.Object[key]; ok && !listed[key] {
+			keys = append(keys, key)
+			listed[key] = true
+		}
+	}
+	var others []string
+	for key := range arr.Object {
+		if !listed[key] {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	keys = append(keys, others...)
+	m := 0
+	for _, key := range keys {
+		m, err = WriteObjectName(w, key)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeValue(w, arr.Object[key])
 		if err != nil {
 			return
 		}
@@ -136,6 +256,117 @@ func WriteEcmaArray(w Writer, arr []interface{}) (n int, err error) {
 	return n + m, err
 }
 
+// strict-array-type = array-count *(value-type)
+func WriteStrictArray(w Writer, arr []interface{}) (n int, err error) {
+	return newAMF0Context().writeStrictArray(w, reflect.ValueOf(arr))
+}
+
+func (ctx *amf0Context) writeStrictArray(w Writer, v reflect.Value) (n int, err error) {
+	if index, ok := ctx.lookupObject(v); ok {
+		return WriteReference(w, uint16(index))
+	}
+	n, err = WriteMarker(w, AMF0_STRICT_ARRAY_MARKER)
+	if err != nil {
+		return
+	}
+	length := uint32(v.Len())
+	err = binary.Write(w, binary.BigEndian, &length)
+	if err != nil {
+		return
+	}
+	n += 4
+	m := 0
+	for i := 0; i < v.Len(); i++ {
+		m, err = ctx.writeReflectValue(w, v.Index(i))
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// reference-type = reference-marker U16
+func WriteReference(w Writer, index uint16) (n int, err error) {
+	err = w.WriteByte(AMF0_REFERENCE_MARKER)
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Write(w, binary.BigEndian, index)
+	if err != nil {
+		return 1, err
+	}
+	return 3, nil
+}
+
+// lookupObject returns the index of v in the reference table if references
+// are enabled and v has been written before. Otherwise v takes the next
+// index in the table.
+func (ctx *amf0Context) lookupObject(v reflect.Value) (int, bool) {
+	if !ctx.references {
+		return 0, false
+	}
+	var key objectKey
+	switch {
+	case v.Kind() == reflect.Map:
+		key = objectKey{v.Type(), v.Pointer()}
+	case v.CanAddr():
+		key = objectKey{v.Type(), v.UnsafeAddr()}
+	default:
+		ctx.objectCount++
+		return 0, false
+	}
+	if index, ok := ctx.objectRefs[key]; ok {
+		return index, true
+	}
+	// The reference is a U16, later objects are always sent inline.
+	if ctx.objectCount <= 0xFFFF {
+		ctx.objectRefs[key] = ctx.objectCount
+	}
+	ctx.objectCount++
+	return 0, false
+}
+
+// WriteDate writes t with the time-zone set to 0x0000.
+func WriteDate(w Writer, t time.Time) (n int, err error) {
+	return WriteDateWithTimeZone(w, t, TimeZoneIgnore)
+}
+
+func WriteDateWithTimeZone(w Writer, t time.Time, mode TimeZoneMode) (n int, err error) {
+	n, err = WriteMarker(w, AMF0_DATE_MARKER)
+	if err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, timeToMillis(t))
+	if err != nil {
+		return
+	}
+	n += 8
+	var timeZone int16
+	if mode == TimeZoneOffset {
+		_, offset := t.Zone()
+		timeZone = int16(offset / -60)
+	}
+	err = binary.Write(w, binary.BigEndian, timeZone)
+	if err != nil {
+		return
+	}
+	return n + 2, nil
+}
+
+// XML document, the text is sent as a long string.
+func WriteXMLDocument(w Writer, doc XMLDocument) (n int, err error) {
+	n, err = WriteMarker(w, AMF0_XML_DOCUMENT_MARKER)
+	if err != nil {
+		return
+	}
+	err = WriteUTF8Long(w, string(doc), uint32(len(doc)))
+	if err != nil {
+		return
+	}
+	return n + 4 + len(doc), nil
+}
+
 func WriteObjectMarker(w Writer) (n int, err error) {
 	return WriteMarker(w, AMF0_OBJECT_MARKER)
 }
@@ -150,8 +381,15 @@ func WriteObjectName(w Writer, name string) (n int, err error) {
 	return int(length + 2), err
 }
 
-// Object's item order is uncertainty.
+// Object's item order is uncertainty, OrderedObject keeps it.
 func WriteObject(w Writer, obj Object) (n int, err error) {
+	return newAMF0Context().writeObject(w, obj)
+}
+
+func (ctx *amf0Context) writeObject(w Writer, obj Object) (n int, err error) {
+	if index, ok := ctx.lookupObject(reflect.ValueOf(obj)); ok {
+		return WriteReference(w, uint16(index))
+	}
 	n, err = WriteObjectMarker(w)
 	if err != nil {
 		return
@@ -163,7 +401,7 @@ func WriteObject(w Writer, obj Object) (n int, err error) {
 			return
 		}
 		n += m
-		m, err = WriteValue(w, value)
+		m, err = ctx.writeValue(w, value)
 		if err != nil {
 			return
 		}
@@ -173,55 +411,141 @@ func WriteObject(w Writer, obj Object) (n int, err error) {
 	return n + m, err
 }
 
-func WriteStruct(w Writer, value reflect.Value) (n int, err error) {
-	var m int
-FOR_LOOP:
-	for i := 0; i < value.NumField(); i++ {
-		structField := value.Type().Field(i)
-		if structField.Anonymous {
-			m, err = WriteStruct(w, value.Field(i))
-			if err != nil {
-				return
-			}
-			n += m
-		} else {
-			name := structField.Tag.Get("amf")
-			switch name {
-			case "":
-				name = structField.Name
-			case "-":
-				continue FOR_LOOP
-			default:
-				if strings.HasSuffix(name, ",omitempty") {
-					if value.IsNil() {
-						continue FOR_LOOP
-					}
-
-					name = strings.Split(name, ",")[0]
-					if len(name) == 0 {
-						name = structField.Name
-					}
-				}
-			}
+func (ctx *amf0Context) writeOrderedObject(w Writer, v reflect.Value) (n int, err error) {
+	if index, ok := ctx.lookupObject(v); ok {
+		return WriteReference(w, uint16(index))
+	}
+	n, err = WriteObjectMarker(w)
+	if err != nil {
+		return
+	}
+	m := 0
+	obj := orderedObjectOf(v)
+	for _, key := range obj.keys {
+		m, err = WriteObjectName(w, key)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeValue(w, obj.values[key])
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	m, err = WriteObjectEndMarker(w)
+	return n + m, err
+}
 
-			m, err = WriteObjectName(w, name)
-			if err != nil {
-				return
-			}
-			n += m
-			field := value.Field(i)
-			m, err = writeValue(w, field)
-			if err != nil {
-				return
-			}
-			n += m
+func WriteTypedObject(w Writer, obj *TypedObject) (n int, err error) {
+	return newAMF0Context().writeReflectValue(w, reflect.ValueOf(obj))
+}
+
+func (ctx *amf0Context) writeTypedObject(w Writer, v reflect.Value) (n int, err error) {
+	if index, ok := ctx.lookupObject(v); ok {
+		return WriteReference(w, uint16(index))
+	}
+	obj := v.Interface().(TypedObject)
+	n, err = WriteMarker(w, AMF0_TYPED_OBJECT_MARKER)
+	if err != nil {
+		return
+	}
+	m := 0
+	m, err = WriteObjectName(w, obj.ClassName)
+	if err != nil {
+		return
+	}
+	n += m
+	var sv stringValues = reflect.ValueOf(obj.Object).MapKeys()
+	sort.Sort(sv)
+	for _, k := range sv {
+		m, err = WriteObjectName(w, k.String())
+		if err != nil {
+			return
 		}
+		n += m
+		m, err = ctx.writeValue(w, obj.Object[k.String()])
+		if err != nil {
+			return
+		}
+		n += m
 	}
+	m, err = WriteObjectEndMarker(w)
+	return n + m, err
+}
 
+func WriteStruct(w Writer, value reflect.Value) (n int, err error) {
+	return newAMF0Context().writeStruct(w, value)
+}
+
+func (ctx *amf0Context) writeStruct(w Writer, value reflect.Value) (n int, err error) {
+	var m int
+	value = addressable(value)
+	for _, f := range structFields(value.Type()) {
+		field, ok := writableField(value, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(field)) {
+			continue
+		}
+		m, err = WriteObjectName(w, f.name)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeReflectValue(w, field)
+		if err != nil {
+			return
+		}
+		n += m
+	}
 	return n, nil
 }
 
 func WriteValue(w Writer, value interface{}) (n int, err error) {
+	return newAMF0Context().writeValue(w, value)
+}
+
+// WriteValueWithReferences is like WriteValue, but a pointer or map that
+// appears more than once in value is sent as a reference after the first
+// time, which also allows cyclic values to be written.
+func WriteValueWithReferences(w Writer, value interface{}) (n int, err error) {
+	ctx := newAMF0Context()
+	ctx.references = true
+	return ctx.writeValue(w, value)
+}
+
+// WriteAVMPlus writes the avmplus-object-marker and then the AMF3 encoding
+// of value.
+func WriteAVMPlus(w Writer, value interface{}) (n int, err error) {
+	return newAMF0Context().writeAVMPlus(w, reflect.ValueOf(value))
+}
+
+func (ctx *amf0Context) writeAVMPlus(w Writer, v reflect.Value) (n int, err error) {
+	n, err = WriteMarker(w, AMF0_ACMPLUS_OBJECT_MARKER)
+	if err != nil {
+		return
+	}
+	m := 0
+	if v.IsValid() {
+		m, err = ctx.amf3.writeReflectValue(w, v)
+	} else {
+		m, err = AMF3_WriteNull(w)
+	}
+	return n + m, err
+}
+
+// isComplex reports whether v is sent in AMF3 when switching to AVM+ for
+// complex values: objects, arrays, dates and XML.
+func isComplex(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	case reflect.Struct:
+		return v.Type() != undefinedType
+	}
+	return v.Type() == xmlDocumentType
+}
+
+func (ctx *amf0Context) writeValue(w Writer, value interface{}) (n int, err error) {
 	if value == nil {
 		return WriteNull(w)
 	}
@@ -229,10 +553,56 @@ func WriteValue(w Writer, value interface{}) (n int, err error) {
 	if !v.IsValid() {
 		return WriteNull(w)
 	}
-	return writeValue(w, v)
+	return ctx.writeReflectValue(w, v)
 }
 
-func writeValue(w Writer, v reflect.Value) (n int, err error) {
+func (ctx *amf0Context) writeReflectValue(w Writer, v reflect.Value) (n int, err error) {
+	if m, ok := interfaceOf(v, amf0MarshalerType); ok {
+		value, err := m.(AMF0Marshaler).MarshalAMF0()
+		if err != nil {
+			return 0, err
+		}
+		return ctx.writeValue(w, value)
+	}
+	if v.Type() == avmPlusType {
+		return ctx.writeAVMPlus(w, v.Field(0).Elem())
+	}
+	if ctx.avmPlus && isComplex(v) {
+		return ctx.writeAVMPlus(w, v)
+	}
+	switch v.Type() {
+	case undefinedType:
+		return WriteUndefined(w)
+	case timeType:
+		return WriteDateWithTimeZone(w, v.Interface().(time.Time), ctx.timeZone)
+	case typedObjectType:
+		return ctx.writeTypedObject(w, v)
+	case xmlDocumentType:
+		return WriteXMLDocument(w, XMLDocument(v.String()))
+	case ecmaArrayType:
+		return ctx.writeEcmaArrayValue(w, v)
+	case orderedObjectType:
+		return ctx.writeOrderedObject(w, v)
+	}
+	if !ctx.references {
+		// Without references a value met again within itself would be
+		// written forever.
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice:
+			if v.IsNil() {
+				break
+			}
+			key := writingKey{objectKey{v.Type(), v.Pointer()}, 0}
+			if v.Kind() == reflect.Slice {
+				key.n = v.Len()
+			}
+			if ctx.writing[key] {
+				return 0, errors.New("Unsupported value: cycle without references")
+			}
+			ctx.writing[key] = true
+			defer delete(ctx.writing, key)
+		}
+	}
 	switch v.Kind() {
 	case reflect.String:
 		return WriteString(w, v.String())
@@ -247,32 +617,10 @@ func writeValue(w Writer, v reflect.Value) (n int, err error) {
 	case reflect.Array:
 		fallthrough
 	case reflect.Slice:
-		// Copy from WriteEcmaArray
-		n, err = WriteMarker(w, AMF0_ECMA_ARRAY_MARKER)
-		if err != nil {
-			return
+		if ctx.strict || v.Type() == strictArrayType {
+			return ctx.writeStrictArray(w, v)
 		}
-		length := int32(v.Len())
-		err = binary.Write(w, binary.BigEndian, &length)
-		if err != nil {
-			return
-		}
-		n += 4
-		m := 0
-		for index := int32(0); index < length; index++ {
-			m, err = WriteObjectName(w, fmt.Sprintf("%d", index))
-			if err != nil {
-				return
-			}
-			n += m
-			m, err = WriteValue(w, v.Index(int(index)).Interface())
-			if err != nil {
-				return
-			}
-			n += m
-		}
-		m, err = WriteObjectEndMarker(w)
-		return n + m, err
+		return ctx.writeEcmaArray(w, v)
 	case reflect.Map:
 		if v.Type().Key().Kind() != reflect.String {
 			return 0, errors.New("Unsupported type")
@@ -280,6 +628,9 @@ func writeValue(w Writer, v reflect.Value) (n int, err error) {
 		if v.IsNil() {
 			return WriteNull(w)
 		}
+		if index, ok := ctx.lookupObject(v); ok {
+			return WriteReference(w, uint16(index))
+		}
 		n, err = WriteObjectMarker(w)
 		if err != nil {
 			return
@@ -293,7 +644,7 @@ func writeValue(w Writer, v reflect.Value) (n int, err error) {
 				return
 			}
 			n += m
-			m, err = WriteValue(w, v.MapIndex(k).Interface())
+			m, err = ctx.writeReflectValue(w, v.MapIndex(k))
 			if err != nil {
 				return
 			}
@@ -301,18 +652,33 @@ func writeValue(w Writer, v reflect.Value) (n int, err error) {
 		}
 		m, err = WriteObjectEndMarker(w)
 		return n + m, err
-	case reflect.Ptr:
-		if v.IsNil() || !v.IsValid() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
 			return WriteNull(w)
 		}
-		return WriteValue(w, v.Elem().Interface())
+		return ctx.writeReflectValue(w, v.Elem())
 	case reflect.Struct:
-		n, err = WriteObjectMarker(w)
-		if err != nil {
-			return
+		if index, ok := ctx.lookupObject(v); ok {
+			return WriteReference(w, uint16(index))
+		}
+		if className := structClassAlias(v); className != "" {
+			n, err = WriteMarker(w, AMF0_TYPED_OBJECT_MARKER)
+			if err != nil {
+				return
+			}
+			err = WriteUTF8(w, className, uint16(len(className)))
+			if err != nil {
+				return
+			}
+			n += 2 + len(className)
+		} else {
+			n, err = WriteObjectMarker(w)
+			if err != nil {
+				return
+			}
 		}
 		m := 0
-		m, err = WriteStruct(w, v)
+		m, err = ctx.writeStruct(w, v)
 		if err != nil {
 			return
 		}
@@ -320,16 +686,6 @@ func writeValue(w Writer, v reflect.Value) (n int, err error) {
 		m, err = WriteObjectEndMarker(w)
 		return n + m, err
 	}
-	value := v.Interface()
-	if value != nil {
-		if _, ok := value.(Undefined); ok {
-			return WriteUndefined(w)
-		} else if vt, ok := value.(Object); ok {
-			return WriteObject(w, vt)
-		} else if vt, ok := value.([]interface{}); ok {
-			return WriteEcmaArray(w, vt)
-		}
-	}
 	return 0, errors.New("Unsupported type")
 }
 
@@ -352,6 +708,19 @@ func ReadString(r Reader) (str string, err error) {
 	}
 	return "", errors.New("Type error")
 }
+
+func ReadXMLDocument(r Reader) (XMLDocument, error) {
+	marker, err := ReadMarker(r)
+	if err != nil {
+		return "", err
+	}
+	if marker != AMF0_XML_DOCUMENT_MARKER {
+		return "", errors.New("Type error")
+	}
+	str, err := ReadUTF8Long(r)
+	return XMLDocument(str), err
+}
+
 func ReadUTF8(r Reader) (string, error) {
 	var stringLength uint16
 	err := binary.Read(r, binary.BigEndian, &stringLength)
@@ -362,7 +731,7 @@ func ReadUTF8(r Reader) (string, error) {
 		return "", nil
 	}
 	data := make([]byte, stringLength)
-	_, err = r.Read(data)
+	_, err = io.ReadFull(r, data)
 	if err != nil {
 		return "", err
 	}
@@ -379,7 +748,7 @@ func ReadUTF8Long(r Reader) (string, error) {
 		return "", nil
 	}
 	data := make([]byte, stringLength)
-	_, err = r.Read(data)
+	_, err = io.ReadFull(r, data)
 	if err != nil {
 		return "", err
 	}
@@ -426,7 +795,22 @@ func ReadObject(r Reader) (obj Object, err error) {
 }
 
 func ReadObjectProperty(r Reader) (Object, error) {
+	return newAMF0Context().readObjectProperty(r)
+}
+
+func (ctx *amf0Context) readObjectProperty(r Reader) (Object, error) {
 	obj := make(Object)
+	ctx.objects = append(ctx.objects, obj)
+	if _, err := ctx.readProperties(r, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// readProperties reads object-properties into obj up to the object-end-marker.
+// It returns the names in the order read.
+func (ctx *amf0Context) readProperties(r Reader, obj Object) ([]string, error) {
+	var keys []string
 	for {
 		name, err := ReadUTF8(r)
 		if err != nil {
@@ -446,15 +830,86 @@ func ReadObjectProperty(r Reader) (Object, error) {
 		if _, ok := obj[name]; ok {
 			return nil, errors.New("object-property exists")
 		}
-		value, err := ReadValue(r)
+		value, err := ctx.readValue(r)
 		if err != nil {
 			return nil, err
 		}
 		obj[name] = value
+		keys = append(keys, name)
+	}
+	return keys, nil
+}
+
+func (ctx *amf0Context) readOrderedObject(r Reader) (*OrderedObject, error) {
+	obj := &OrderedObject{values: make(Object)}
+	ctx.objects = append(ctx.objects, obj)
+	keys, err := ctx.readProperties(r, obj.values)
+	if err != nil {
+		return nil, err
+	}
+	obj.keys = keys
+	return obj, nil
+}
+
+// ecma-array-type = associative-count *(object-property) object-end-marker
+func ReadEcmaArray(r Reader) (*ECMAArray, error) {
+	return newAMF0Context().readEcmaArray(r)
+}
+
+func (ctx *amf0Context) readEcmaArray(r Reader) (*ECMAArray, error) {
+	arr := &ECMAArray{Object: make(Object)}
+	if err := binary.Read(r, binary.BigEndian, &arr.Count); err != nil {
+		return nil, err
+	}
+	ctx.objects = append(ctx.objects, arr)
+	keys, err := ctx.readProperties(r, arr.Object)
+	if err != nil {
+		return nil, err
+	}
+	arr.Keys = keys
+	return arr, nil
+}
+
+// typed-object-type = object-marker class-name *(object-property) object-end-marker
+func ReadTypedObject(r Reader) (*TypedObject, error) {
+	return newAMF0Context().readTypedObject(r)
+}
+
+func (ctx *amf0Context) readTypedObject(r Reader) (*TypedObject, error) {
+	className, err := ReadUTF8(r)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.readTypedObjectBody(r, className)
+}
+
+func (ctx *amf0Context) readTypedObjectBody(r Reader, className string) (*TypedObject, error) {
+	obj := &TypedObject{
+		ClassName: className,
+		Object:    make(Object),
+	}
+	ctx.objects = append(ctx.objects, obj)
+	if _, err := ctx.readProperties(r, obj.Object); err != nil {
+		return nil, err
 	}
 	return obj, nil
 }
 
+// readAliasObject reads a typed object of a class registered with
+// RegisterClassAlias into a new value of the registered type.
+func (ctx *amf0Context) readAliasObject(r Reader, t reflect.Type) (interface{}, error) {
+	ptr := reflect.New(t)
+	ctx.objects = append(ctx.objects, ptr.Interface())
+	props := make(Object)
+	if _, err := ctx.readProperties(r, props); err != nil {
+		return nil, err
+	}
+	if err := newAliasValue(ptr, props, AMF0); err != nil {
+		return nil, err
+	}
+	return ptr.Interface(), nil
+}
+
 // A strict Array contains only ordinal indices; however, in AMF 0 the indices can be dense
 // or sparse. Undefined entries in the sparse regions between indices are serialized as
 // undefined.
@@ -464,23 +919,22 @@ func ReadObjectProperty(r Reader) (Object, error) {
 //
 // A 32-bit array-count implies a theoretical maximum of 4,294,967,295 array entries.
 func ReadStrictArray(r Reader) (arr []interface{}, err error) {
+	return newAMF0Context().readStrictArray(r)
+}
+
+func (ctx *amf0Context) readStrictArray(r Reader) (arr []interface{}, err error) {
 	var arrayCount uint32
 	err = binary.Read(r, binary.BigEndian, &arrayCount)
 	if err != nil {
 		return nil, err
 	}
-	if arrayCount == 0 {
-		return
-	}
-	arr = make([]interface{}, arrayCount)
-
-	for i := uint32(0); i < arrayCount; i++ {
-		arr[i], err = ReadValue(r)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return
+	index := len(ctx.objects)
+	ctx.objects = append(ctx.objects, nil)
+	return readItems(int(arrayCount), func() (interface{}, error) {
+		return ctx.readValue(r)
+	}, func(arr interface{}) {
+		ctx.objects[index] = arr
+	})
 }
 
 // An ActionScript Date is serialized as the number of milliseconds elapsed since the epoch
@@ -494,29 +948,43 @@ func ReadStrictArray(r Reader) (arr []interface{}, err error) {
 // Keng-die: time-zone = int16 * -60 (seconds)
 //                                                                 ; to 0x0000
 // date-type                = date-marker DOUBLE time-zone
+//
+// ReadDate ignores the time-zone and returns the date in UTC.
 func ReadDate(r Reader) (t time.Time, err error) {
+	return ReadDateWithTimeZone(r, TimeZoneIgnore)
+}
+
+func ReadDateWithTimeZone(r Reader, mode TimeZoneMode) (t time.Time, err error) {
 	var d float64
 	var timeZone int16
-	if err = binary.Read(r, binary.BigEndian, &d); err == nil {
-		// time-zone
-		err = binary.Read(r, binary.BigEndian, &timeZone)
-		if err != nil {
-			fmt.Printf("ReadDate() Read time zone err: %s\n", err)
-			return
-		}
-		d /= 1000.0
-		//		d += float64(timeZone) * -60.0
-		sec := int64(d)
-		nsec := int64((d - float64(sec)) * 1000000000.0)
-		t = time.Unix(sec, nsec)
-	} else {
-		fmt.Printf("ReadDate() ReadDouble err: %s\n", err)
-
+	if err = binary.Read(r, binary.BigEndian, &d); err != nil {
+		return
+	}
+	if err = binary.Read(r, binary.BigEndian, &timeZone); err != nil {
+		return
+	}
+	t = millisToTime(d)
+	if mode == TimeZoneOffset && timeZone != 0 {
+		t = t.In(time.FixedZone("", int(timeZone)*-60))
 	}
 	return
 }
 
 func ReadValue(r Reader) (value interface{}, err error) {
+	return newAMF0Context().readValue(r)
+}
+
+// ReadValueWithOrderedObjects is like ReadValue, but anonymous objects are
+// returned as *OrderedObject in the order of their properties. This holds
+// for AMF3 objects after an avmplus-object-marker too.
+func ReadValueWithOrderedObjects(r Reader) (value interface{}, err error) {
+	ctx := newAMF0Context()
+	ctx.ordered = true
+	ctx.amf3.ordered = true
+	return ctx.readValue(r)
+}
+
+func (ctx *amf0Context) readValue(r Reader) (value interface{}, err error) {
 	marker, err := ReadMarker(r)
 	if err != nil {
 		return 0, err
@@ -535,7 +1003,10 @@ func ReadValue(r Reader) (value interface{}, err error) {
 	case AMF0_STRING_MARKER:
 		return ReadUTF8(r)
 	case AMF0_OBJECT_MARKER:
-		return ReadObjectProperty(r)
+		if ctx.ordered {
+			return ctx.readOrderedObject(r)
+		}
+		return ctx.readObjectProperty(r)
 	case AMF0_MOVIECLIP_MARKER:
 		return nil, errors.New("Unsupported type: movie clip")
 	case AMF0_NULL_MARKER:
@@ -543,25 +1014,23 @@ func ReadValue(r Reader) (value interface{}, err error) {
 	case AMF0_UNDEFINED_MARKER:
 		return Undefined{}, nil
 	case AMF0_REFERENCE_MARKER:
-		return nil, errors.New("Unsupported type: reference")
-	case AMF0_ECMA_ARRAY_MARKER:
-		// Decode ECMA Array to object
-		arrLen := make([]byte, 4)
-		_, err = r.Read(arrLen)
+		var index uint16
+		err = binary.Read(r, binary.BigEndian, &index)
 		if err != nil {
 			return nil, err
 		}
-		obj, err := ReadObjectProperty(r)
-		if err != nil {
-			return nil, err
+		if int(index) >= len(ctx.objects) {
+			return nil, errors.New("AMF0 reference out of range")
 		}
-		return obj, nil
+		return resolveReference(ctx.objects[index]), nil
+	case AMF0_ECMA_ARRAY_MARKER:
+		return ctx.readEcmaArray(r)
 	case AMF0_OBJECT_END_MARKER:
 		return nil, errors.New("Marker error, Object end")
 	case AMF0_STRICT_ARRAY_MARKER:
-		return ReadStrictArray(r)
+		return ctx.readStrictArray(r)
 	case AMF0_DATE_MARKER:
-		return ReadDate(r)
+		return ReadDateWithTimeZone(r, ctx.timeZone)
 	case AMF0_LONG_STRING_MARKER:
 		return ReadUTF8Long(r)
 	case AMF0_UNSUPPORTED_MARKER:
@@ -569,11 +1038,22 @@ func ReadValue(r Reader) (value interface{}, err error) {
 	case AMF0_RECORDSET_MARKER:
 		return nil, errors.New("Unsupported type: recordset")
 	case AMF0_XML_DOCUMENT_MARKER:
-		return nil, errors.New("Unsupported type: XML document")
+		str, err := ReadUTF8Long(r)
+		if err != nil {
+			return nil, err
+		}
+		return XMLDocument(str), nil
 	case AMF0_TYPED_OBJECT_MARKER:
-		return nil, errors.New("Unsupported type: typed object")
+		className, err := ReadUTF8(r)
+		if err != nil {
+			return nil, err
+		}
+		if t := aliasType(className); t != nil {
+			return ctx.readAliasObject(r, t)
+		}
+		return ctx.readTypedObjectBody(r, className)
 	case AMF0_ACMPLUS_OBJECT_MARKER:
-		return AMF3_ReadValue(r)
+		return ctx.amf3.readValue(r)
 	}
 	return nil, errors.New(fmt.Sprintf("Unknown marker type: %d", marker))
 }
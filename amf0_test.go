@@ -3,14 +3,16 @@ package amf
 import (
 	"bytes"
 	"encoding/binary"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestWriteMarker(t *testing.T) {
 	buf := new(bytes.Buffer)
 	n, err := WriteMarker(buf, AMF0_NUMBER_MARKER)
 	if err != nil {
-		t.Errorf("test %s err", "WriteMark", err)
+		t.Errorf("test %s err: %s", "WriteMark", err)
 	} else {
 		expect := []byte{0x00}
 		got := buf.Bytes()
@@ -506,3 +508,337 @@ func TestDecodeObject(t *testing.T) {
 		t.Errorf("ReadObject loss some items: %v", expect)
 	}
 }
+
+func TestEncodeDate(t *testing.T) {
+	date := time.Date(2013, 1, 2, 11, 4, 5, 678000000, time.FixedZone("CST", 8*3600))
+	buf := new(bytes.Buffer)
+	n, err := WriteValue(buf, &date)
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	if n != 11 {
+		t.Errorf("WriteValue return n: %d\n", n)
+	}
+	expect := []byte{0x0b, 0x42, 0x73, 0xbf, 0x93, 0x73, 0xf2, 0xe0, 0x00, 0x00, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("WriteValue expect % x got % x", expect, got)
+	}
+
+	buf = new(bytes.Buffer)
+	_, err = WriteDateWithTimeZone(buf, date, TimeZoneOffset)
+	if err != nil {
+		t.Fatalf("WriteDateWithTimeZone error: %s", err)
+	}
+	expect = []byte{0x0b, 0x42, 0x73, 0xbf, 0x93, 0x73, 0xf2, 0xe0, 0x00, 0xfe, 0x20} // -480
+	got = buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("WriteDateWithTimeZone expect % x got % x", expect, got)
+	}
+}
+
+func TestDecodeDate(t *testing.T) {
+	data := []byte{0x0b, 0x42, 0x73, 0xbf, 0x93, 0x73, 0xf2, 0xe0, 0x00, 0xfe, 0x20}
+	expect := time.Date(2013, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	got, err := ReadValue(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	if date, ok := got.(time.Time); !ok || !date.Equal(expect) || date.Location() != time.UTC {
+		t.Errorf("ReadValue return %v, expect %v", got, expect)
+	}
+
+	date, err := ReadDateWithTimeZone(bytes.NewReader(data[1:]), TimeZoneOffset)
+	if err != nil {
+		t.Fatalf("ReadDateWithTimeZone error: %s", err)
+	}
+	if _, offset := date.Zone(); !date.Equal(expect) || offset != 8*3600 {
+		t.Errorf("ReadDateWithTimeZone return %v", date)
+	}
+
+	_, err = ReadDate(bytes.NewReader(data[1:9]))
+	if err == nil {
+		t.Errorf("ReadDate expect error for missing time-zone")
+	}
+}
+
+func TestEncodeReference(t *testing.T) {
+	sub := map[string]string{"a": "b"}
+	obj := Object{"x": sub, "y": sub}
+	buf := new(bytes.Buffer)
+	_, err := WriteValueWithReferences(buf, obj)
+	if err != nil {
+		t.Fatalf("WriteValueWithReferences error: %s", err)
+	}
+	expect := []byte{0x03,
+		0x00, 0x01, 'x', 0x03, 0x00, 0x01, 'a', 0x02, 0x00, 0x01, 'b', 0x00, 0x00, 0x09,
+		0x00, 0x01, 'y', 0x07, 0x00, 0x01, // reference 1
+		0x00, 0x00, 0x09,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("WriteValueWithReferences\n   got: % 2x\nexpect: % 2x\n", got, expect)
+	}
+
+	// Without references the object is written twice.
+	buf = new(bytes.Buffer)
+	_, err = WriteValue(buf, obj)
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	if buf.Len() != len(expect)-3+11 {
+		t.Errorf("WriteValue writen buffer len: %d\n", buf.Len())
+	}
+}
+
+func TestDecodeReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x00, 0x00, 0x00, 0x03,
+		0x03, 0x00, 0x01, 'a', 0x02, 0x00, 0x01, 'b', 0x00, 0x00, 0x09,
+		0x07, 0x00, 0x01, // the object
+		0x07, 0x00, 0x00, // the strict array itself
+	})
+	got, err := ReadValue(buf)
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	arr, ok := got.([]interface{})
+	if !ok || len(arr) != 3 {
+		t.Fatalf("ReadValue return %v", got)
+	}
+	obj, ok := arr[0].(Object)
+	if !ok || obj["a"] != "b" {
+		t.Fatalf("ReadValue [0] return %v", arr[0])
+	}
+	if ref, ok := arr[1].(Object); !ok || reflect.ValueOf(ref).Pointer() != reflect.ValueOf(obj).Pointer() {
+		t.Errorf("ReadValue [1] return %v", arr[1])
+	}
+	if ref, ok := arr[2].([]interface{}); !ok || &ref[0] != &arr[0] {
+		t.Errorf("ReadValue [2] return %v", arr[2])
+	}
+
+	_, err = ReadValue(bytes.NewReader([]byte{0x07, 0x00, 0x00}))
+	if err == nil {
+		t.Errorf("ReadValue expect error for unknown reference")
+	}
+}
+
+type testUser struct {
+	Name string `amf:"name"`
+}
+
+func (u *testUser) ClassAlias() string { return "com.example.User" }
+
+func TestEncodeTypedObject(t *testing.T) {
+	expect := []byte{0x10,
+		0x00, 0x10, 'c', 'o', 'm', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'U', 's', 'e', 'r',
+		0x00, 0x04, 'n', 'a', 'm', 'e', 0x02, 0x00, 0x05, 'z', 'h', 'a', 'n', 'g',
+		0x00, 0x00, 0x09,
+	}
+	values := []interface{}{
+		&TypedObject{ClassName: "com.example.User", Object: Object{"name": "zhang"}},
+		&testUser{Name: "zhang"},
+		testUser{Name: "zhang"},
+	}
+	for _, value := range values {
+		buf := new(bytes.Buffer)
+		n, err := WriteValue(buf, value)
+		if err != nil {
+			t.Errorf("WriteValue(%T) error: %s", value, err)
+			continue
+		}
+		if n != len(expect) {
+			t.Errorf("WriteValue(%T) return n: %d, expect %d\n", value, n, len(expect))
+		}
+		got := buf.Bytes()
+		if !bytes.Equal(expect, got) {
+			t.Errorf("WriteValue(%T)\n   got: % 2x\nexpect: % 2x\n", value, got, expect)
+		}
+	}
+}
+
+func TestDecodeTypedObject(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x10,
+		0x00, 0x03, 'F', 'o', 'o',
+		0x00, 0x01, 'a', 0x02, 0x00, 0x01, 'b',
+		0x00, 0x00, 0x09,
+	})
+	got, err := ReadValue(buf)
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	obj, ok := got.(*TypedObject)
+	if !ok {
+		t.Fatalf("ReadValue return %T, expect *TypedObject", got)
+	}
+	if obj.ClassName != "Foo" || len(obj.Object) != 1 || obj.Object["a"] != "b" {
+		t.Errorf("ReadValue return %+v", obj)
+	}
+}
+
+func TestXMLDocument(t *testing.T) {
+	buf := new(bytes.Buffer)
+	n, err := WriteValue(buf, XMLDocument("<a/>"))
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	expect := []byte{0x0f, 0x00, 0x00, 0x00, 0x04, '<', 'a', '/', '>'}
+	if n != len(expect) || !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("WriteValue expect % x got % x (%d)", expect, buf.Bytes(), n)
+	}
+	got, err := ReadValue(buf)
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	if got != XMLDocument("<a/>") {
+		t.Errorf("ReadValue return %#v", got)
+	}
+}
+
+func TestEncodeStrictArray(t *testing.T) {
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x02, 0x00, 0x01, 'a',
+		0x01, 0x01,
+	}
+	buf := new(bytes.Buffer)
+	n, err := WriteStrictArray(buf, []interface{}{"a", true})
+	if err != nil {
+		t.Fatalf("WriteStrictArray error: %s", err)
+	}
+	if n != len(expect) || !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("WriteStrictArray expect % x got % x (%d)", expect, buf.Bytes(), n)
+	}
+
+	buf.Reset()
+	if _, err = WriteValue(buf, StrictArray{"a", true}); err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("WriteValue expect % x got % x", expect, buf.Bytes())
+	}
+
+	buf.Reset()
+	enc := NewEncoder(buf, AMF0)
+	enc.StrictArrays = true
+	if err = enc.Encode([]interface{}{"a", true}); err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("Encode expect % x got % x", expect, buf.Bytes())
+	}
+
+	value, err := ReadValue(buf)
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	if arr, ok := value.([]interface{}); !ok || len(arr) != 2 || arr[0] != "a" || arr[1] != true {
+		t.Errorf("ReadValue return %#v", value)
+	}
+}
+
+func TestEcmaArray(t *testing.T) {
+	// onMetaData with an associative-count of 0 as some encoders send it
+	data := []byte{0x08, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x08, 'd', 'u', 'r', 'a', 't', 'i', 'o', 'n', 0x00, 0x40, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x05, 'w', 'i', 'd', 't', 'h', 0x00, 0x40, 0x74, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x06, 'h', 'e', 'i', 'g', 'h', 't', 0x00, 0x40, 0x6e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x09,
+	}
+	value, err := ReadValue(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	arr, ok := value.(*ECMAArray)
+	if !ok {
+		t.Fatalf("ReadValue return %T, expect *ECMAArray", value)
+	}
+	if arr.Count != 0 || !reflect.DeepEqual(arr.Keys, []string{"duration", "width", "height"}) ||
+		arr.Object["width"] != float64(320) {
+		t.Errorf("ReadValue return %+v", arr)
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := WriteValue(buf, arr)
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	if n != len(data) || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("WriteValue\n   got: % x\nexpect: % x", buf.Bytes(), data)
+	}
+}
+
+func TestOrderedObject(t *testing.T) {
+	data := []byte{0x03,
+		0x00, 0x01, 'z', 0x02, 0x00, 0x01, '1',
+		0x00, 0x01, 'a', 0x02, 0x00, 0x01, '2',
+		0x00, 0x00, 0x09,
+	}
+	value, err := ReadValueWithOrderedObjects(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadValueWithOrderedObjects error: %s", err)
+	}
+	obj, ok := value.(*OrderedObject)
+	if !ok {
+		t.Fatalf("ReadValueWithOrderedObjects return %T, expect *OrderedObject", value)
+	}
+	if !reflect.DeepEqual(obj.Keys(), []string{"z", "a"}) {
+		t.Errorf("ReadValueWithOrderedObjects keys %v", obj.Keys())
+	}
+	buf := new(bytes.Buffer)
+	n, err := WriteValue(buf, obj)
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	if n != len(data) || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("WriteValue\n   got: % x\nexpect: % x", buf.Bytes(), data)
+	}
+
+	obj.Set("z", "3")
+	obj.Set("m", "4")
+	obj.Delete("a")
+	if !reflect.DeepEqual(obj.Keys(), []string{"z", "m"}) || obj.Len() != 2 {
+		t.Errorf("OrderedObject keys %v", obj.Keys())
+	}
+	if v, ok := obj.Get("z"); !ok || v != "3" {
+		t.Errorf("OrderedObject Get return %v, %v", v, ok)
+	}
+
+	if value, _ = ReadValue(bytes.NewReader(data)); reflect.TypeOf(value) != reflect.TypeOf(Object{}) {
+		t.Errorf("ReadValue return %T, expect Object", value)
+	}
+}
+
+func TestDecodeStrictArrayCount(t *testing.T) {
+	if _, err := ReadValue(bytes.NewReader([]byte{0x0a, 0xff, 0xff, 0xff, 0xff})); err == nil {
+		t.Errorf("ReadValue of truncated strict array expect error")
+	}
+
+	// References to an array read before it is complete get the final slice
+	count := maxPrealloc*2 - 48
+	buf := bytes.NewBuffer([]byte{0x0a})
+	binary.Write(buf, binary.BigEndian, uint32(count))
+	for i := 0; i < count; i++ {
+		switch i {
+		case 5:
+			buf.Write([]byte{0x07, 0x00, 0x00})
+		case 6:
+			buf.Write([]byte{0x03, 0x00, 0x01, 'a', 0x07, 0x00, 0x00, 0x00, 0x00, 0x09})
+		default:
+			buf.WriteByte(0x05)
+		}
+	}
+	got, err := ReadValue(buf)
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	arr, ok := got.([]interface{})
+	if !ok || len(arr) != count {
+		t.Fatalf("ReadValue return %T of %d items", got, len(arr))
+	}
+	if self, ok := arr[5].([]interface{}); !ok || len(self) != count || &self[0] != &arr[0] {
+		t.Errorf("ReadValue self reference is %T of %d items", arr[5], len(self))
+	}
+	obj, _ := arr[6].(Object)
+	if self, ok := obj["a"].([]interface{}); !ok || len(self) != count || &self[0] != &arr[0] {
+		t.Errorf("ReadValue nested reference is %T of %d items", obj["a"], len(self))
+	}
+}
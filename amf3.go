@@ -6,10 +6,74 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
 	"sort"
+	"time"
 )
 
+// AMF3 reference tables.
+//
+// Strings, complex objects and traits are sent once per message and then
+// referred to by index. An amf3Context holds those tables for the duration of
+// one message; every exported AMF3_ function starts from a fresh context,
+// an Encoder or Decoder keeps one until Reset.
+type amf3Context struct {
+	// Read side
+	strings []string
+	objects []interface{}
+	traits  []*AMF3Traits
+
+	// Write side
+	stringRefs  map[string]int
+	objectRefs  map[objectKey]int
+	objectCount int
+	traitsRefs  map[string]int
+
+	// Write []int32, []uint32 and []float64 as vectors instead of arrays.
+	vectors bool
+	// Read anonymous objects as *OrderedObject instead of Object.
+	ordered bool
+}
+
+func newAMF3Context() *amf3Context {
+	return &amf3Context{
+		stringRefs: make(map[string]int),
+		objectRefs: make(map[objectKey]int),
+		traitsRefs: make(map[string]int),
+	}
+}
+
+// Sizes of the write side tables, to drop what a failed value added.
+type amf3Mark struct {
+	strings, objects, traits int
+}
+
+func (ctx *amf3Context) mark() amf3Mark {
+	return amf3Mark{len(ctx.stringRefs), ctx.objectCount, len(ctx.traitsRefs)}
+}
+
+// rollback drops the write side table entries added since m.
+func (ctx *amf3Context) rollback(m amf3Mark) {
+	for str, index := range ctx.stringRefs {
+		if index >= m.strings {
+			delete(ctx.stringRefs, str)
+		}
+	}
+	for key, index := range ctx.objectRefs {
+		if index >= m.objects {
+			delete(ctx.objectRefs, key)
+		}
+	}
+	ctx.objectCount = m.objects
+	for key, index := range ctx.traitsRefs {
+		if index >= m.traits {
+			delete(ctx.traitsRefs, key)
+		}
+	}
+}
+
 //-----------------------------------------------------------------------
 // AMF3 Write functions
 func AMF3_WriteU29(w Writer, n uint32) (num int, err error) {
@@ -31,12 +95,16 @@ func AMF3_WriteU29(w Writer, n uint32) (num int, err error) {
 }
 
 func AMF3_WriteString(w Writer, str string) (n int, err error) {
+	return newAMF3Context().writeString(w, str)
+}
+
+func (ctx *amf3Context) writeString(w Writer, str string) (n int, err error) {
 	err = w.WriteByte(AMF3_STRING_MARKER)
 	if err != nil {
 		return 0, err
 	}
 
-	n, err = AMF3_WriteUTF8(w, str)
+	n, err = ctx.writeUTF8(w, str)
 	if err != nil {
 		return 1, err
 	}
@@ -44,6 +112,13 @@ func AMF3_WriteString(w Writer, str string) (n int, err error) {
 }
 
 func AMF3_WriteUTF8(w Writer, str string) (num int, err error) {
+	return newAMF3Context().writeUTF8(w, str)
+}
+
+// UTF-8-vr = U29S-ref | (U29S-value *(UTF8-char))
+//
+// The empty string is never sent by reference.
+func (ctx *amf3Context) writeUTF8(w Writer, str string) (num int, err error) {
 	length := len(str)
 	if length == 0 {
 		err = w.WriteByte(0x01)
@@ -53,7 +128,11 @@ func AMF3_WriteUTF8(w Writer, str string) (num int, err error) {
 			return 1, nil
 		}
 	}
-	u := uint32((length << 1) | 0x01) // Todo: reference
+	if index, ok := ctx.stringRefs[str]; ok {
+		return AMF3_WriteU29(w, uint32(index<<1))
+	}
+	ctx.stringRefs[str] = len(ctx.stringRefs)
+	u := uint32((length << 1) | 0x01)
 	n, err := AMF3_WriteU29(w, u)
 	if err != nil {
 		return 0, err
@@ -65,6 +144,24 @@ func AMF3_WriteUTF8(w Writer, str string) (num int, err error) {
 	return m + n, nil
 }
 
+// Range of the signed 29-bit AMF3 integer
+const (
+	AMF3_INTEGER_MIN = -1 << 28
+	AMF3_INTEGER_MAX = 1<<28 - 1
+)
+
+func AMF3_WriteInteger(w Writer, num int32) (n int, err error) {
+	if num < AMF3_INTEGER_MIN || num > AMF3_INTEGER_MAX {
+		return 0, errors.New("AMF3 integer out of range")
+	}
+	err = w.WriteByte(AMF3_INTEGER_MARKER)
+	if err != nil {
+		return 0, err
+	}
+	n, err = AMF3_WriteU29(w, uint32(num)&0x1FFFFFFF)
+	return n + 1, err
+}
+
 func AMF3_WriteDouble(w Writer, num float64) (n int, err error) {
 	err = w.WriteByte(AMF3_DOUBLE_MARKER)
 	if err != nil {
@@ -105,6 +202,35 @@ func AMF3_WriteUndefined(w Writer) (n int, err error) {
 	return 1, nil
 }
 
+func AMF3_WriteDate(w Writer, t time.Time) (n int, err error) {
+	return newAMF3Context().writeDate(w, reflect.ValueOf(t))
+}
+
+// date-type = date-marker (U29O-ref | (U29D-value date-time))
+//
+// The date is sent as milliseconds since the epoch in UTC.
+func (ctx *amf3Context) writeDate(w Writer, v reflect.Value) (n int, err error) {
+	n, err = WriteMarker(w, AMF3_DATE_MARKER)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	err = w.WriteByte(0x01)
+	if err != nil {
+		return
+	}
+	n += 1
+	err = binary.Write(w, binary.BigEndian, timeToMillis(v.Interface().(time.Time)))
+	if err != nil {
+		return
+	}
+	return n + 8, nil
+}
+
 func AMF3_WriteObjectMarker(w Writer) (n int, err error) {
 	return WriteMarker(w, AMF3_OBJECT_MARKER)
 }
@@ -121,42 +247,473 @@ func AMF3_WriteObjectName(w Writer, name string) (n int, err error) {
 	return AMF3_WriteUTF8(w, name)
 }
 
-// Object's item order is uncertainty.
-func AMF3_WriteObject(w Writer, obj Object) (n int, err error) {
+// Object's item order is uncertainty.
+func AMF3_WriteObject(w Writer, obj Object) (n int, err error) {
+	return newAMF3Context().writeObject(w, obj)
+}
+
+func (ctx *amf3Context) writeObject(w Writer, obj Object) (n int, err error) {
+	n, err = AMF3_WriteObjectMarker(w)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(reflect.ValueOf(obj)); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	m, err = ctx.writeTraits(w, &anonymousTraits)
+	if err != nil {
+		return
+	}
+	n += m
+	for key, value := range obj {
+		m, err = ctx.writeUTF8(w, key)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeValue(w, value)
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	m, err = AMF3_WriteObjectEndMarker(w)
+	return n + m, err
+}
+
+// U29O-traits-ref | U29O-traits
+func (ctx *amf3Context) writeTraits(w Writer, traits *AMF3Traits) (n int, err error) {
+	key := traits.key()
+	if index, ok := ctx.traitsRefs[key]; ok {
+		return AMF3_WriteU29(w, uint32(index<<2|0x01))
+	}
+	ctx.traitsRefs[key] = len(ctx.traitsRefs)
+	u := uint32(len(traits.Members)<<4 | 0x03)
+	if traits.Externalizable {
+		u = 0x07
+	} else if traits.Dynamic {
+		u |= 0x08
+	}
+	n, err = AMF3_WriteU29(w, u)
+	if err != nil {
+		return
+	}
+	m := 0
+	m, err = ctx.writeUTF8(w, traits.ClassName)
+	if err != nil {
+		return
+	}
+	n += m
+	if traits.Externalizable {
+		return
+	}
+	for _, member := range traits.Members {
+		m, err = ctx.writeUTF8(w, member)
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+func (ctx *amf3Context) writeAMF3Object(w Writer, v reflect.Value) (n int, err error) {
+	n, err = AMF3_WriteObjectMarker(w)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	obj := v.Interface().(AMF3Object)
+	traits := obj.Traits
+	if traits == nil {
+		traits = &anonymousTraits
+	}
+	if len(obj.Sealed) != len(traits.Members) {
+		return n, errors.New("AMF3 object sealed values do not match traits")
+	}
+	m, err = ctx.writeTraits(w, traits)
+	if err != nil {
+		return
+	}
+	n += m
+	for _, value := range obj.Sealed {
+		m, err = ctx.writeValue(w, value)
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	if !traits.Dynamic {
+		return
+	}
+	var sv stringValues = reflect.ValueOf(obj.Dynamic).MapKeys()
+	sort.Sort(sv)
+	for _, k := range sv {
+		m, err = ctx.writeUTF8(w, k.String())
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeValue(w, obj.Dynamic[k.String()])
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	m, err = AMF3_WriteObjectEndMarker(w)
+	return n + m, err
+}
+
+// writeArray writes an array whose identity is v, with the given dense
+// part and an optional string keyed associative part.
+func (ctx *amf3Context) writeArray(w Writer, v, dense, associative reflect.Value) (n int, err error) {
+	n, err = WriteMarker(w, AMF3_ARRAY_MARKER)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	// Write dense array length
+	length := dense.Len()
+	m, err = AMF3_WriteU29(w, uint32((length<<1)|0x01))
+	if err != nil {
+		return
+	}
+	n += m
+	if associative.IsValid() {
+		var sv stringValues = associative.MapKeys()
+		sort.Sort(sv)
+		for _, k := range sv {
+			if k.String() == "" {
+				return n, errors.New("AMF3 associative array key is empty")
+			}
+			m, err = ctx.writeUTF8(w, k.String())
+			if err != nil {
+				return
+			}
+			n += m
+			m, err = ctx.writeReflectValue(w, associative.MapIndex(k))
+			if err != nil {
+				return
+			}
+			n += m
+		}
+	}
+	// Empty string to end associative array
+	err = w.WriteByte(0x01)
+	if err != nil {
+		return
+	}
+	n += 1
+	for i := 0; i < length; i++ {
+		m, err = ctx.writeReflectValue(w, dense.Index(i))
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// U29V-ref | U29V-value fixed-vector [object-type-name] items
+//
+// items is a slice of int32, uint32 or float64 kind, or the []interface{}
+// of an object vector of type typeName.
+func (ctx *amf3Context) writeVector(w Writer, marker byte, v, items reflect.Value, typeName string, fixed bool) (n int, err error) {
+	n, err = WriteMarker(w, marker)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	length := items.Len()
+	m, err = AMF3_WriteU29(w, uint32((length<<1)|0x01))
+	if err != nil {
+		return
+	}
+	n += m
+	var b byte
+	if fixed {
+		b = 0x01
+	}
+	if err = w.WriteByte(b); err != nil {
+		return
+	}
+	n += 1
+	if marker != AMF3_VECTOR_OBJECT_MARKER {
+		b := make([]byte, 8)
+		for i := 0; i < length; i++ {
+			item := items.Index(i)
+			switch marker {
+			case AMF3_VECTOR_INT_MARKER:
+				binary.BigEndian.PutUint32(b, uint32(item.Int()))
+				m, err = w.Write(b[:4])
+			case AMF3_VECTOR_UINT_MARKER:
+				binary.BigEndian.PutUint32(b, uint32(item.Uint()))
+				m, err = w.Write(b[:4])
+			default:
+				binary.BigEndian.PutUint64(b, math.Float64bits(item.Float()))
+				m, err = w.Write(b)
+			}
+			if err != nil {
+				return
+			}
+			n += m
+		}
+		return
+	}
+	m, err = ctx.writeUTF8(w, typeName)
+	if err != nil {
+		return
+	}
+	n += m
+	for i := 0; i < length; i++ {
+		m, err = ctx.writeReflectValue(w, items.Index(i))
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// writeOrderedObject writes an anonymous object with the members in order.
+func (ctx *amf3Context) writeOrderedObject(w Writer, v reflect.Value) (n int, err error) {
+	n, err = AMF3_WriteObjectMarker(w)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	m, err = ctx.writeTraits(w, &anonymousTraits)
+	if err != nil {
+		return
+	}
+	n += m
+	obj := orderedObjectOf(v)
+	for _, key := range obj.keys {
+		if key == "" {
+			return n, errors.New("AMF3 object member name is empty")
+		}
+		m, err = ctx.writeUTF8(w, key)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeValue(w, obj.values[key])
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	m, err = AMF3_WriteObjectEndMarker(w)
+	return n + m, err
+}
+
+// U29X-ref | U29X-value UTF8-char*, for XMLDocument and XML. Unlike other
+// strings they go to the object table.
+func (ctx *amf3Context) writeXML(w Writer, marker byte, v reflect.Value) (n int, err error) {
+	n, err = WriteMarker(w, marker)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	str := v.String()
+	m, err = AMF3_WriteU29(w, uint32((len(str)<<1)|0x01))
+	if err != nil {
+		return
+	}
+	n += m
+	m, err = w.Write([]byte(str))
+	return n + m, err
+}
+
+// U29Dict-ref | U29Dict-value weak-keys *(entry-key entry-value)
+func (ctx *amf3Context) writeDictionary(w Writer, v reflect.Value) (n int, err error) {
+	n, err = WriteMarker(w, AMF3_DICTIONARY_MARKER)
+	if err != nil {
+		return
+	}
+	m := 0
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
+	}
+	entries := v.FieldByName("Entries")
+	m, err = AMF3_WriteU29(w, uint32((entries.Len()<<1)|0x01))
+	if err != nil {
+		return
+	}
+	n += m
+	var b byte
+	if v.FieldByName("WeakKeys").Bool() {
+		b = 0x01
+	}
+	if err = w.WriteByte(b); err != nil {
+		return
+	}
+	n += 1
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.Index(i)
+		m, err = ctx.writeReflectValue(w, entry.Field(0))
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeReflectValue(w, entry.Field(1))
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// lookupObject returns the index of v in the object table if it has been
+// written before. Otherwise v takes the next index in the table.
+//
+// Only maps and addressable values have an identity; anything else is
+// never found, but still takes an index like the reader expects.
+func (ctx *amf3Context) lookupObject(v reflect.Value) (int, bool) {
+	var key objectKey
+	switch {
+	case v.Kind() == reflect.Map:
+		key = objectKey{v.Type(), v.Pointer()}
+	case v.CanAddr():
+		key = objectKey{v.Type(), v.UnsafeAddr()}
+	default:
+		ctx.objectCount++
+		return 0, false
+	}
+	if index, ok := ctx.objectRefs[key]; ok {
+		return index, true
+	}
+	ctx.objectRefs[key] = ctx.objectCount
+	ctx.objectCount++
+	return 0, false
+}
+
+func (ctx *amf3Context) writeStruct(w Writer, value reflect.Value) (n int, err error) {
+	var m int
+	value = addressable(value)
+	for _, f := range structFields(value.Type()) {
+		field, ok := writableField(value, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(field)) {
+			continue
+		}
+		m, err = ctx.writeUTF8(w, f.name)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeReflectValue(w, field)
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// writeSealedStruct writes the traits and members of a struct with a class
+// name, the fields are the sealed members.
+func (ctx *amf3Context) writeSealedStruct(w Writer, value reflect.Value, className string) (n int, err error) {
+	traits := &AMF3Traits{ClassName: className}
+	var fields []reflect.Value
+	value = addressable(value)
+	for _, f := range structFields(value.Type()) {
+		field, ok := writableField(value, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(field)) {
+			continue
+		}
+		traits.Members = append(traits.Members, f.name)
+		fields = append(fields, field)
+	}
+	n, err = ctx.writeTraits(w, traits)
+	if err != nil {
+		return
+	}
+	m := 0
+	for _, field := range fields {
+		m, err = ctx.writeReflectValue(w, field)
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// writeExternal writes v as an externalizable object, the body is written
+// by its WriteExternal.
+func (ctx *amf3Context) writeExternal(w Writer, v reflect.Value, ext Externalizable) (n int, err error) {
+	className := structClassAlias(v)
+	if className == "" {
+		return 0, errors.New("Unsupported type: externalizable object without class alias")
+	}
 	n, err = AMF3_WriteObjectMarker(w)
 	if err != nil {
 		return
 	}
 	m := 0
-	// Write traits flag, Todo: traits class support
-	err = w.WriteByte(0x0b)
-	if err != nil {
-		return
+	if index, ok := ctx.lookupObject(v); ok {
+		m, err = AMF3_WriteU29(w, uint32(index<<1))
+		return n + m, err
 	}
-	n += 1
-	// Write empty class name
-	m, err = AMF3_WriteUTF8(w, "")
+	m, err = ctx.writeTraits(w, &AMF3Traits{ClassName: className, Externalizable: true})
 	if err != nil {
 		return
 	}
 	n += m
-	for key, value := range obj {
-		m, err = AMF3_WriteObjectName(w, key)
-		if err != nil {
-			return
-		}
-		n += m
-		m, err = AMF3_WriteValue(w, value)
-		if err != nil {
-			return
-		}
-		n += m
+	cw := &countWriter{w: w}
+	err = ext.WriteExternal(&Encoder{w: cw, version: AMF3, amf3: ctx, Vectors: ctx.vectors})
+	return n + cw.n, err
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w Writer
+	n int
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+func (cw *countWriter) WriteByte(c byte) error {
+	err := cw.w.WriteByte(c)
+	if err == nil {
+		cw.n++
 	}
-	m, err = AMF3_WriteObjectEndMarker(w)
-	return n + m, err
+	return err
 }
 
 func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
+	return newAMF3Context().writeValue(w, value)
+}
+
+func (ctx *amf3Context) writeValue(w Writer, value interface{}) (n int, err error) {
 	if value == nil {
 		return AMF3_WriteNull(w)
 	}
@@ -164,14 +721,84 @@ func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
 	if !v.IsValid() {
 		return AMF3_WriteNull(w)
 	}
+	return ctx.writeReflectValue(w, v)
+}
+
+func (ctx *amf3Context) writeReflectValue(w Writer, v reflect.Value) (n int, err error) {
+	if m, ok := interfaceOf(v, amf3MarshalerType); ok {
+		value, err := m.(AMF3Marshaler).MarshalAMF3()
+		if err != nil {
+			return 0, err
+		}
+		return ctx.writeValue(w, value)
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		if !v.CanAddr() && reflect.PtrTo(v.Type()).Implements(externalizableType) {
+			// Make a copy to call the pointer methods on
+			ptr := reflect.New(v.Type())
+			ptr.Elem().Set(v)
+			v = ptr.Elem()
+		}
+		if ext, ok := interfaceOf(v, externalizableType); ok {
+			return ctx.writeExternal(w, v, ext.(Externalizable))
+		}
+	}
+	switch v.Type() {
+	case undefinedType:
+		return AMF3_WriteUndefined(w)
+	case amf3ObjectType:
+		return ctx.writeAMF3Object(w, v)
+	case orderedObjectType:
+		return ctx.writeOrderedObject(w, v)
+	case ecmaArrayType:
+		// The associative part of an array, ordered by name
+		return ctx.writeArray(w, v, reflect.ValueOf([]interface{}(nil)), v.FieldByName("Object"))
+	case avmPlusType:
+		return ctx.writeValue(w, v.Field(0).Interface())
+	case typedObjectType:
+		typed := v.Interface().(TypedObject)
+		return ctx.writeAMF3Object(w, reflect.ValueOf(AMF3Object{
+			Traits:  &AMF3Traits{ClassName: typed.ClassName, Dynamic: true},
+			Dynamic: typed.Object,
+		}))
+	case mixedArrayType:
+		return ctx.writeArray(w, v, v.FieldByName("Dense"), v.FieldByName("Associative"))
+	case objectVectorType:
+		return ctx.writeVector(w, AMF3_VECTOR_OBJECT_MARKER, v, v.FieldByName("Items"),
+			v.FieldByName("TypeName").String(), v.FieldByName("Fixed").Bool())
+	case dictionaryType:
+		return ctx.writeDictionary(w, v)
+	case xmlDocumentType:
+		return ctx.writeXML(w, AMF3_XMLDOC_MARKER, v)
+	case xmlType:
+		return ctx.writeXML(w, AMF3_XML_MARKER, v)
+	case timeType:
+		return ctx.writeDate(w, v)
+	}
+	if ctx.vectors && v.Kind() == reflect.Slice {
+		switch v.Type().Elem().Kind() {
+		case reflect.Int32:
+			return ctx.writeVector(w, AMF3_VECTOR_INT_MARKER, v, v, "", false)
+		case reflect.Uint32:
+			return ctx.writeVector(w, AMF3_VECTOR_UINT_MARKER, v, v, "", false)
+		case reflect.Float64:
+			return ctx.writeVector(w, AMF3_VECTOR_DOUBLE_MARKER, v, v, "", false)
+		}
+	}
 	switch v.Kind() {
 	case reflect.String:
-		return AMF3_WriteString(w, value.(string))
+		return ctx.writeString(w, v.String())
 	case reflect.Bool:
 		return AMF3_WriteBoolean(w, v.Bool())
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i >= AMF3_INTEGER_MIN && i <= AMF3_INTEGER_MAX {
+			return AMF3_WriteInteger(w, int32(i))
+		}
 		return AMF3_WriteDouble(w, float64(v.Int()))
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := v.Uint(); u <= AMF3_INTEGER_MAX {
+			return AMF3_WriteInteger(w, int32(u))
+		}
 		return AMF3_WriteDouble(w, float64(v.Uint()))
 	case reflect.Float32, reflect.Float64:
 		return AMF3_WriteDouble(w, v.Float())
@@ -184,9 +811,17 @@ func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
 			if err != nil {
 				return
 			}
-			b := v.Bytes()
+			ctx.objectCount++
+			var b []byte
+			if v.Kind() == reflect.Array {
+				// Bytes needs the array to be addressable
+				b = make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+			} else {
+				b = v.Bytes()
+			}
 			length := len(b)
-			u := uint32((length << 1) | 0x01) // Todo: reference
+			u := uint32((length << 1) | 0x01)
 			var m int
 			m, err = AMF3_WriteU29(w, u)
 			if err != nil {
@@ -199,55 +834,25 @@ func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
 			}
 			n += m
 			return
-		} else {
-			// Array
-			// Dense array only
-			n, err = WriteMarker(w, AMF3_ARRAY_MARKER)
-			if err != nil {
-				return
-			}
-			// Write dense array length
-			length := v.Len()
-			u := uint32((length << 1) | 0x01) // Todo: reference
-			var m int
-			m, err = AMF3_WriteU29(w, u)
-			if err != nil {
-				return
-			}
-			n += m
-			// Todo: Associative array
-			// Empty string to end associative array
-			err = w.WriteByte(0x01)
-			if err != nil {
-				return
-			}
-			n += 1
-			for i := 0; i < length; i++ {
-				m, err = AMF3_WriteValue(w, v.Index(i).Interface())
-				if err != nil {
-					return
-				}
-				n += m
-			}
-			return
 		}
+		return ctx.writeArray(w, v, v, reflect.Value{})
 	case reflect.Map:
 		if v.Type().Key().Kind() != reflect.String {
 			return 0, errors.New("Unsupported type")
 		}
+		if v.IsNil() {
+			return AMF3_WriteNull(w)
+		}
 		n, err = AMF3_WriteObjectMarker(w)
 		if err != nil {
 			return
 		}
 		m := 0
-		// Write traits flag, Todo: traits class support
-		err = w.WriteByte(0x0b)
-		if err != nil {
-			return
+		if index, ok := ctx.lookupObject(v); ok {
+			m, err = AMF3_WriteU29(w, uint32(index<<1))
+			return n + m, err
 		}
-		n += 1
-		// Write empty class name
-		m, err = AMF3_WriteUTF8(w, "")
+		m, err = ctx.writeTraits(w, &anonymousTraits)
 		if err != nil {
 			return
 		}
@@ -256,12 +861,12 @@ func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
 		var sv stringValues = v.MapKeys()
 		sort.Sort(sv)
 		for _, k := range sv {
-			m, err = AMF3_WriteObjectName(w, k.String())
+			m, err = ctx.writeUTF8(w, k.String())
 			if err != nil {
 				return
 			}
 			n += m
-			m, err = AMF3_WriteValue(w, v.MapIndex(k).Interface())
+			m, err = ctx.writeReflectValue(w, v.MapIndex(k))
 			if err != nil {
 				return
 			}
@@ -270,14 +875,37 @@ func AMF3_WriteValue(w Writer, value interface{}) (n int, err error) {
 
 		m, err = AMF3_WriteObjectEndMarker(w)
 		return n + m, err
-
-	}
-	if _, ok := value.(Undefined); ok {
-		return AMF3_WriteUndefined(w)
-	} else if vt, ok := value.(Object); ok {
-		return AMF3_WriteObject(w, vt)
-	} else if vt, ok := value.([]interface{}); ok {
-		fmt.Printf("Todo: WriteValue: %+v\n", vt)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return AMF3_WriteNull(w)
+		}
+		return ctx.writeReflectValue(w, v.Elem())
+	case reflect.Struct:
+		n, err = AMF3_WriteObjectMarker(w)
+		if err != nil {
+			return
+		}
+		m := 0
+		if index, ok := ctx.lookupObject(v); ok {
+			m, err = AMF3_WriteU29(w, uint32(index<<1))
+			return n + m, err
+		}
+		if className := structClassAlias(v); className != "" {
+			m, err = ctx.writeSealedStruct(w, v, className)
+			return n + m, err
+		}
+		m, err = ctx.writeTraits(w, &anonymousTraits)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = ctx.writeStruct(w, v)
+		if err != nil {
+			return
+		}
+		n += m
+		m, err = AMF3_WriteObjectEndMarker(w)
+		return n + m, err
 	}
 	return 0, errors.New("Unsupported type")
 }
@@ -304,6 +932,10 @@ func AMF3_ReadU29(r Reader) (n uint32, err error) {
 }
 
 func AMF3_ReadUTF8(r Reader) (string, error) {
+	return newAMF3Context().readUTF8(r)
+}
+
+func (ctx *amf3Context) readUTF8(r Reader) (string, error) {
 	var length uint32
 	var err error
 	length, err = AMF3_ReadU29(r)
@@ -311,19 +943,24 @@ func AMF3_ReadUTF8(r Reader) (string, error) {
 		return "", err
 	}
 	if length&uint32(0x01) != uint32(1) {
-		// Todo: reference
-		return "", errors.New("AMF3 reference unsupported")
+		index := int(length >> 1)
+		if index >= len(ctx.strings) {
+			return "", errors.New("AMF3 string reference out of range")
+		}
+		return ctx.strings[index], nil
 	}
 	length = length >> 1
 	if length == 0 {
 		return "", nil
 	}
 	data := make([]byte, length)
-	_, err = r.Read(data)
+	_, err = io.ReadFull(r, data)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	str := string(data)
+	ctx.strings = append(ctx.strings, str)
+	return str, nil
 }
 
 func AMF3_ReadString(r Reader) (str string, err error) {
@@ -337,7 +974,7 @@ func AMF3_ReadString(r Reader) (str string, err error) {
 	return AMF3_ReadUTF8(r)
 }
 
-func AMF3_ReadInteger(r Reader) (num uint32, err error) {
+func AMF3_ReadInteger(r Reader) (num int32, err error) {
 	marker, err := ReadMarker(r)
 	if err != nil {
 		return 0, err
@@ -345,7 +982,19 @@ func AMF3_ReadInteger(r Reader) (num uint32, err error) {
 	if marker != AMF3_INTEGER_MARKER {
 		return 0, errors.New("Type error")
 	}
-	return AMF3_ReadU29(r)
+	return amf3_readInteger(r)
+}
+
+// amf3_readInteger reads a U29 and sign-extends it from 29 bits.
+func amf3_readInteger(r Reader) (int32, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return 0, err
+	}
+	if u&0x10000000 != 0 {
+		return int32(u) - 0x20000000, nil
+	}
+	return int32(u), nil
 }
 
 func AMF3_ReadDouble(r Reader) (num float64, err error) {
@@ -360,6 +1009,43 @@ func AMF3_ReadDouble(r Reader) (num float64, err error) {
 	return
 }
 
+func AMF3_ReadDate(r Reader) (t time.Time, err error) {
+	marker, err := ReadMarker(r)
+	if err != nil {
+		return
+	}
+	if marker != AMF3_DATE_MARKER {
+		return t, errors.New("Type error")
+	}
+	return newAMF3Context().readDate(r)
+}
+
+func (ctx *amf3Context) readDate(r Reader) (t time.Time, err error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return
+	}
+	if u&0x01 == 0 {
+		value, err := ctx.objectReference(u)
+		if err != nil {
+			return t, err
+		}
+		t, ok := value.(time.Time)
+		if !ok {
+			return t, errors.New("Type error")
+		}
+		return t, nil
+	}
+	var ms float64
+	err = binary.Read(r, binary.BigEndian, &ms)
+	if err != nil {
+		return
+	}
+	t = millisToTime(ms)
+	ctx.objects = append(ctx.objects, t)
+	return t, nil
+}
+
 func AMF3_ReadObjectName(r Reader) (name string, err error) {
 	return AMF3_ReadUTF8(r)
 }
@@ -376,41 +1062,197 @@ func AMF3_ReadObject(r Reader) (obj Object, err error) {
 }
 
 func AMF3_ReadObjectProperty(r Reader) (Object, error) {
-	obj := make(Object)
-	// Read traits flag
-	b, err := r.ReadByte()
+	return newAMF3Context().readObjectProperty(r)
+}
+
+func (ctx *amf3Context) readObjectProperty(r Reader) (Object, error) {
+	value, err := ctx.readObjectValue(r)
 	if err != nil {
 		return nil, err
 	}
-	if b != 0x0b {
-		return nil, errors.New("Unsupported type: traits object")
+	switch obj := value.(type) {
+	case Object:
+		return obj, nil
+	case *AMF3Object:
+		return obj.Properties(), nil
 	}
-	// Read empty string
-	b, err = r.ReadByte()
+	return nil, errors.New("Type error")
+}
+
+// U29O-ref | U29O-traits-ref | U29O-traits ...
+//
+// Anonymous objects without sealed members decode to Object, anything
+// else to *AMF3Object.
+func (ctx *amf3Context) readObjectValue(r Reader) (interface{}, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return nil, err
+	}
+	if u&0x01 == 0 {
+		return ctx.objectReference(u)
+	}
+	traits, err := ctx.readTraits(r, u)
+	if err != nil {
+		return nil, err
+	}
+	if traits.Externalizable {
+		return ctx.readExternal(r, traits)
+	}
+
+	if t := aliasType(traits.ClassName); t != nil {
+		return ctx.readAliasObject(r, traits, t)
+	}
+	if traits.ClassName == "" && len(traits.Members) == 0 && ctx.ordered {
+		obj := &OrderedObject{values: make(Object)}
+		ctx.objects = append(ctx.objects, obj)
+		if traits.Dynamic {
+			if obj.keys, err = ctx.readDynamicMembers(r, obj.values); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+	if traits.ClassName == "" && len(traits.Members) == 0 {
+		obj := make(Object)
+		ctx.objects = append(ctx.objects, obj)
+		if traits.Dynamic {
+			if _, err = ctx.readDynamicMembers(r, obj); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+	typed := &AMF3Object{
+		Traits: traits,
+		Sealed: make([]interface{}, len(traits.Members)),
+	}
+	ctx.objects = append(ctx.objects, typed)
+	for i := range typed.Sealed {
+		typed.Sealed[i], err = ctx.readValue(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if traits.Dynamic {
+		typed.Dynamic = make(Object)
+		if _, err = ctx.readDynamicMembers(r, typed.Dynamic); err != nil {
+			return nil, err
+		}
+	}
+	return typed, nil
+}
+
+// readAliasObject reads an object of a class registered with
+// RegisterClassAlias into a new value of the registered type.
+func (ctx *amf3Context) readAliasObject(r Reader, traits *AMF3Traits, t reflect.Type) (interface{}, error) {
+	ptr := reflect.New(t)
+	ctx.objects = append(ctx.objects, ptr.Interface())
+	props := make(Object)
+	for _, member := range traits.Members {
+		value, err := ctx.readValue(r)
+		if err != nil {
+			return nil, err
+		}
+		props[member] = value
+	}
+	if traits.Dynamic {
+		if _, err := ctx.readDynamicMembers(r, props); err != nil {
+			return nil, err
+		}
+	}
+	if err := newAliasValue(ptr, props, AMF3); err != nil {
+		return nil, err
+	}
+	return ptr.Interface(), nil
+}
+
+// readExternal reads an externalizable object by the ReadExternal of the
+// type registered for its class. The body of an unknown class cannot be
+// skipped, so that is an error.
+func (ctx *amf3Context) readExternal(r Reader, traits *AMF3Traits) (interface{}, error) {
+	t := aliasType(traits.ClassName)
+	if t == nil || !reflect.PtrTo(t).Implements(externalizableType) {
+		return nil, errors.New("Unsupported type: externalizable object " + traits.ClassName)
+	}
+	ptr := reflect.New(t)
+	index := len(ctx.objects)
+	ctx.objects = append(ctx.objects, ptr.Interface())
+	dec := &Decoder{r: r, version: AMF3, amf3: ctx, OrderedObjects: ctx.ordered}
+	err := ptr.Interface().(Externalizable).ReadExternal(dec)
 	if err != nil {
 		return nil, err
 	}
-	if b != 0x01 {
-		return nil, errors.New("Unsupported type: traits object")
+	if u, ok := ptr.Interface().(unwrapper); ok {
+		// Later references get the unwrapped value too.
+		ctx.objects[index] = u.unwrap()
 	}
+	return ctx.objects[index], nil
+}
+
+// readDynamicMembers reads name/value pairs into obj up to the empty name.
+// It returns the names in the order read.
+func (ctx *amf3Context) readDynamicMembers(r Reader, obj Object) ([]string, error) {
+	var keys []string
 	for {
-		name, err := AMF3_ReadObjectName(r)
+		name, err := ctx.readUTF8(r)
 		if err != nil {
 			return nil, err
 		}
 		if name == "" {
-			break
+			return keys, nil
 		}
 		if _, ok := obj[name]; ok {
 			return nil, errors.New("object-property exists")
 		}
-		value, err := AMF3_ReadValue(r)
+		value, err := ctx.readValue(r)
 		if err != nil {
 			return nil, err
 		}
 		obj[name] = value
+		keys = append(keys, name)
 	}
-	return obj, nil
+}
+
+// readTraits reads the traits of an object, u is the U29O header already
+// consumed by the caller.
+func (ctx *amf3Context) readTraits(r Reader, u uint32) (*AMF3Traits, error) {
+	if u&0x02 == 0 {
+		index := int(u >> 2)
+		if index >= len(ctx.traits) {
+			return nil, errors.New("AMF3 traits reference out of range")
+		}
+		return ctx.traits[index], nil
+	}
+	className, err := ctx.readUTF8(r)
+	if err != nil {
+		return nil, err
+	}
+	traits := &AMF3Traits{
+		ClassName:      className,
+		Externalizable: u&0x04 != 0,
+		Dynamic:        u&0x08 != 0,
+	}
+	if !traits.Externalizable {
+		count := int(u >> 4)
+		traits.Members = make([]string, count)
+		for i := 0; i < count; i++ {
+			traits.Members[i], err = ctx.readUTF8(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	ctx.traits = append(ctx.traits, traits)
+	return traits, nil
+}
+
+// objectReference resolves a U29O-ref, u with the low bit cleared.
+func (ctx *amf3Context) objectReference(u uint32) (interface{}, error) {
+	index := int(u >> 1)
+	if index >= len(ctx.objects) {
+		return nil, errors.New("AMF3 object reference out of range")
+	}
+	return resolveReference(ctx.objects[index]), nil
 }
 
 func AMF3_ReadByteArray(r Reader) ([]byte, error) {
@@ -425,26 +1267,223 @@ func AMF3_ReadByteArray(r Reader) ([]byte, error) {
 }
 
 func AMF3_readByteArray(r Reader) ([]byte, error) {
+	return newAMF3Context().readByteArray(r)
+}
+
+func (ctx *amf3Context) readByteArray(r Reader) ([]byte, error) {
 	length, err := AMF3_ReadU29(r)
 	if err != nil {
 		return nil, err
 	}
 	if length&uint32(0x01) != uint32(0x01) {
-		return nil, errors.New("Unsupported type: reference")
+		value, err := ctx.objectReference(length)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := value.([]byte)
+		if !ok {
+			return nil, errors.New("Type error")
+		}
+		return b, nil
 	}
 	length = (length >> 1)
 	buf := make([]byte, length)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, err
 	}
 	if n != int(length) {
 		return nil, errors.New("Read buffer size error")
 	}
+	ctx.objects = append(ctx.objects, buf)
 	return buf, nil
 }
 
+// U29A-value (UTF-8-empty | *(assoc-value) UTF-8-empty) *(value-type)
+//
+// An array without associative members decodes to []interface{}, anything
+// else to *MixedArray.
+func (ctx *amf3Context) readArray(r Reader) (interface{}, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return nil, err
+	}
+	if u&0x01 == 0 {
+		return ctx.objectReference(u)
+	}
+	count := int(u >> 1)
+	// Register the array before reading any member, members may refer to it.
+	index := len(ctx.objects)
+	mixed := &MixedArray{Associative: make(Object)}
+	ctx.objects = append(ctx.objects, mixed)
+	for {
+		name, err := ctx.readUTF8(r)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			break
+		}
+		value, err := ctx.readValue(r)
+		if err != nil {
+			return nil, err
+		}
+		mixed.Associative[name] = value
+	}
+	dense, err := readItems(count, func() (interface{}, error) {
+		return ctx.readValue(r)
+	}, func(dense interface{}) {
+		if len(mixed.Associative) == 0 {
+			ctx.objects[index] = dense
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(mixed.Associative) == 0 {
+		return dense, nil
+	}
+	mixed.Dense = dense
+	return mixed, nil
+}
+
+// U29V-ref | U29V-value fixed-vector [object-type-name] items
+//
+// Vectors of int, uint and Number decode to []int32, []uint32 and
+// []float64, object vectors to *ObjectVector.
+func (ctx *amf3Context) readVector(r Reader, marker byte) (interface{}, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return nil, err
+	}
+	if u&0x01 == 0 {
+		return ctx.objectReference(u)
+	}
+	count := int(u >> 1)
+	fixed, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if marker == AMF3_VECTOR_OBJECT_MARKER {
+		typeName, err := ctx.readUTF8(r)
+		if err != nil {
+			return nil, err
+		}
+		vector := &ObjectVector{
+			TypeName: typeName,
+			Fixed:    fixed != 0,
+		}
+		ctx.objects = append(ctx.objects, vector)
+		vector.Items, err = readItems(count, func() (interface{}, error) {
+			return ctx.readValue(r)
+		}, func(interface{}) {})
+		if err != nil {
+			return nil, err
+		}
+		return vector, nil
+	}
+	// Items are read one at a time, the slice grows with them.
+	var items interface{}
+	b := make([]byte, 8)
+	switch marker {
+	case AMF3_VECTOR_INT_MARKER:
+		vector := make([]int32, 0, preallocLen(count))
+		for i := 0; i < count; i++ {
+			if _, err = io.ReadFull(r, b[:4]); err != nil {
+				return nil, err
+			}
+			vector = append(vector, int32(binary.BigEndian.Uint32(b)))
+		}
+		items = vector
+	case AMF3_VECTOR_UINT_MARKER:
+		vector := make([]uint32, 0, preallocLen(count))
+		for i := 0; i < count; i++ {
+			if _, err = io.ReadFull(r, b[:4]); err != nil {
+				return nil, err
+			}
+			vector = append(vector, binary.BigEndian.Uint32(b))
+		}
+		items = vector
+	default:
+		vector := make([]float64, 0, preallocLen(count))
+		for i := 0; i < count; i++ {
+			if _, err = io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			vector = append(vector, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		}
+		items = vector
+	}
+	ctx.objects = append(ctx.objects, items)
+	return items, nil
+}
+
+// U29X-ref | U29X-value UTF8-char*
+func (ctx *amf3Context) readXML(r Reader, marker byte) (interface{}, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return nil, err
+	}
+	if u&0x01 == 0 {
+		return ctx.objectReference(u)
+	}
+	b := make([]byte, u>>1)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var value interface{} = XML(b)
+	if marker == AMF3_XMLDOC_MARKER {
+		value = XMLDocument(b)
+	}
+	ctx.objects = append(ctx.objects, value)
+	return value, nil
+}
+
+// U29Dict-ref | U29Dict-value weak-keys *(entry-key entry-value)
+func (ctx *amf3Context) readDictionary(r Reader) (interface{}, error) {
+	u, err := AMF3_ReadU29(r)
+	if err != nil {
+		return nil, err
+	}
+	if u&0x01 == 0 {
+		return ctx.objectReference(u)
+	}
+	count := int(u >> 1)
+	weakKeys, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	dict := &Dictionary{
+		WeakKeys: weakKeys != 0,
+		Entries:  make([]DictionaryEntry, 0, preallocLen(count)),
+	}
+	ctx.objects = append(ctx.objects, dict)
+	for i := 0; i < count; i++ {
+		var entry DictionaryEntry
+		if entry.Key, err = ctx.readValue(r); err != nil {
+			return nil, err
+		}
+		if entry.Value, err = ctx.readValue(r); err != nil {
+			return nil, err
+		}
+		dict.Entries = append(dict.Entries, entry)
+	}
+	return dict, nil
+}
+
 func AMF3_ReadValue(r Reader) (value interface{}, err error) {
+	return newAMF3Context().readValue(r)
+}
+
+// AMF3_ReadValueWithOrderedObjects is like AMF3_ReadValue, but anonymous
+// objects are returned as *OrderedObject in the order of their members.
+func AMF3_ReadValueWithOrderedObjects(r Reader) (value interface{}, err error) {
+	ctx := newAMF3Context()
+	ctx.ordered = true
+	return ctx.readValue(r)
+}
+
+func (ctx *amf3Context) readValue(r Reader) (value interface{}, err error) {
 	marker, err := ReadMarker(r)
 	if err != nil {
 		return 0, err
@@ -459,19 +1498,28 @@ func AMF3_ReadValue(r Reader) (value interface{}, err error) {
 	case AMF3_TRUE_MARKER:
 		return true, nil
 	case AMF3_INTEGER_MARKER:
-		return AMF3_ReadU29(r)
+		return amf3_readInteger(r)
 	case AMF3_DOUBLE_MARKER:
 		var num float64
 		err = binary.Read(r, binary.BigEndian, &num)
 		return num, err
 	case AMF3_STRING_MARKER:
-		return AMF3_ReadUTF8(r)
+		return ctx.readUTF8(r)
+	case AMF3_DATE_MARKER:
+		return ctx.readDate(r)
 	case AMF3_ARRAY_MARKER:
-		// Todo: read array
+		return ctx.readArray(r)
 	case AMF3_OBJECT_MARKER:
-		return AMF3_ReadObjectProperty(r)
+		return ctx.readObjectValue(r)
 	case AMF3_BYTEARRAY_MARKER:
-		return AMF3_readByteArray(r)
+		return ctx.readByteArray(r)
+	case AMF3_VECTOR_INT_MARKER, AMF3_VECTOR_UINT_MARKER,
+		AMF3_VECTOR_DOUBLE_MARKER, AMF3_VECTOR_OBJECT_MARKER:
+		return ctx.readVector(r, marker)
+	case AMF3_DICTIONARY_MARKER:
+		return ctx.readDictionary(r)
+	case AMF3_XMLDOC_MARKER, AMF3_XML_MARKER:
+		return ctx.readXML(r, marker)
 	}
 
 	return nil, errors.New(fmt.Sprintf("Unknown marker type: %d", marker))
@@ -2,7 +2,9 @@ package amf
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type testU29Case struct {
@@ -334,7 +336,8 @@ func TestAMF3_DecodeDouble(t *testing.T) {
 var testAMF3_DecodeCases = []TestEncodeValueCase{
 	{"1.2", 1.2, []byte{0x05, 0x3f, 0xf3, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33}},
 	{"float64(1.2)", float64(1.2), []byte{0x05, 0x3f, 0xf3, 0x33, 0x33, 0x33, 0x33, 0x33, 0x33}},
-	{"1", uint32(1), []byte{0x04, 0x01}},
+	{"1", int32(1), []byte{0x04, 0x01}},
+	{"-1", int32(-1), []byte{0x04, 0xff, 0xff, 0xff, 0xff}},
 	{"foo", "foo", []byte{0x06, 0x07, 'f', 'o', 'o'}},
 	{"empty string", "", []byte{0x06, 0x01}},
 	{"false", false, []byte{0x02}},
@@ -405,3 +408,525 @@ func TestAMF3_DecodeByteArray(t *testing.T) {
 	}
 
 }
+
+func TestAMF3_EncodeStringReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	obj := Object{"foo": "foo", "bar": ""}
+	_, err := AMF3_WriteValue(buf, obj)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x0A, 0x0B, 0x01,
+		0x07, 'b', 'a', 'r', 0x06, 0x01, // bar: "" (never referenced)
+		0x07, 'f', 'o', 'o', 0x06, 0x02, // foo: reference 1
+		0x01,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, got)
+	}
+}
+
+func TestAMF3_DecodeStringReference(t *testing.T) {
+	buf := bytes.NewReader(
+		[]byte{0x0A, 0x0B, 0x01,
+			0x03, 'a', 0x06, 0x07, 'f', 'o', 'o',
+			0x03, 'b', 0x06, 0x02, // "foo"
+			0x03, 'c', 0x06, 0x00, // "a"
+			0x01,
+		})
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	obj, ok := got.(Object)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect Object", got)
+	}
+	if obj["a"] != "foo" || obj["b"] != "foo" || obj["c"] != "a" {
+		t.Errorf("AMF3_ReadValue return %v", obj)
+	}
+
+	buf = bytes.NewReader([]byte{0x06, 0x00})
+	if _, err = AMF3_ReadValue(buf); err == nil {
+		t.Errorf("AMF3_ReadValue expect error for unknown string reference")
+	}
+}
+
+type testAMF3Node struct {
+	Name string
+	Next *testAMF3Node
+}
+
+func TestAMF3_EncodeObjectReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	obj := Object{}
+	obj["self"] = obj
+	_, err := AMF3_WriteValue(buf, obj)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x0A, 0x0B, 0x01,
+		0x09, 's', 'e', 'l', 'f', 0x0A, 0x00, // self: reference 0
+		0x01,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue(cyclic map) expect % x got % x", expect, got)
+	}
+
+	buf = new(bytes.Buffer)
+	node := &testAMF3Node{Name: "a"}
+	node.Next = node
+	_, err = AMF3_WriteValue(buf, []interface{}{node, node})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect = []byte{0x09, 0x05, 0x01,
+		0x0A, 0x0B, 0x01,
+		0x09, 'N', 'a', 'm', 'e', 0x06, 0x03, 'a',
+		0x09, 'N', 'e', 'x', 't', 0x0A, 0x02, // Next: reference 1
+		0x01,
+		0x0A, 0x02, // reference 1
+	}
+	got = buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue(cyclic struct) expect % x got % x", expect, got)
+	}
+}
+
+func TestAMF3_DecodeObjectReference(t *testing.T) {
+	buf := bytes.NewReader(
+		[]byte{0x0A, 0x0B, 0x01,
+			0x09, 's', 'e', 'l', 'f', 0x0A, 0x00,
+			0x03, 'b', 0x0C, 0x07, 'f', 'o', 'o',
+			0x03, 'c', 0x0C, 0x02,
+			0x01,
+		})
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	obj, ok := got.(Object)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect Object", got)
+	}
+	self, ok := obj["self"].(Object)
+	if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(obj).Pointer() {
+		t.Errorf("AMF3_ReadValue self reference not resolved: %v", obj["self"])
+	}
+	if b, ok := obj["c"].([]byte); !ok || string(b) != "foo" {
+		t.Errorf("AMF3_ReadValue byte array reference not resolved: %v", obj["c"])
+	}
+}
+
+func TestAMF3_DecodeTraits(t *testing.T) {
+	buf := bytes.NewReader(
+		[]byte{0x0A, 0x1B, 0x07, 'F', 'o', 'o', 0x03, 'a', // Foo, dynamic, sealed: a
+			0x06, 0x03, 'x', // a: "x"
+			0x03, 'b', 0x0A, 0x01, // b: Foo (traits reference 0)
+			0x06, 0x03, 'y', // a: "y"
+			0x01, // end of b
+			0x01,
+		})
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	obj, ok := got.(*AMF3Object)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect *AMF3Object", got)
+	}
+	if obj.Traits.ClassName != "Foo" || !obj.Traits.Dynamic || len(obj.Traits.Members) != 1 || obj.Traits.Members[0] != "a" {
+		t.Errorf("AMF3_ReadValue traits: %+v", obj.Traits)
+	}
+	if len(obj.Sealed) != 1 || obj.Sealed[0] != "x" {
+		t.Errorf("AMF3_ReadValue sealed: %v", obj.Sealed)
+	}
+	b, ok := obj.Dynamic["b"].(*AMF3Object)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue dynamic b: %T, expect *AMF3Object", obj.Dynamic["b"])
+	}
+	if b.Traits != obj.Traits || b.Sealed[0] != "y" || len(b.Dynamic) != 0 {
+		t.Errorf("AMF3_ReadValue b: %+v", b)
+	}
+}
+
+func TestAMF3_EncodeTraits(t *testing.T) {
+	traits := &AMF3Traits{ClassName: "Foo", Members: []string{"a"}}
+	obj := Object{
+		"p": &AMF3Object{Traits: traits, Sealed: []interface{}{"x"}},
+		"q": &AMF3Object{Traits: traits, Sealed: []interface{}{"y"}},
+	}
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, obj)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x0A, 0x0B, 0x01,
+		0x03, 'p', 0x0A, 0x13, 0x07, 'F', 'o', 'o', 0x03, 'a', 0x06, 0x03, 'x',
+		0x03, 'q', 0x0A, 0x05, 0x06, 0x03, 'y', // traits reference 1
+		0x01,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, got)
+	}
+}
+
+func TestAMF3_DecodeArray(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x05, 0x01, 0x06, 0x03, 'a', 0x09, 0x00})
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	arr, ok := got.([]interface{})
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect []interface{}", got)
+	}
+	if len(arr) != 2 || arr[0] != "a" {
+		t.Fatalf("AMF3_ReadValue return %v", arr)
+	}
+	if self, ok := arr[1].([]interface{}); !ok || &self[0] != &arr[0] {
+		t.Errorf("AMF3_ReadValue self reference not resolved: %v", arr[1])
+	}
+
+	buf = bytes.NewReader([]byte{0x09, 0x03,
+		0x03, 'k', 0x06, 0x03, 'v',
+		0x01,
+		0x06, 0x03, 'a',
+	})
+	got, err = AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	mixed, ok := got.(*MixedArray)
+	if !ok {
+		t.Fatalf("AMF3_ReadValue return %T, expect *MixedArray", got)
+	}
+	if len(mixed.Dense) != 1 || mixed.Dense[0] != "a" || len(mixed.Associative) != 1 || mixed.Associative["k"] != "v" {
+		t.Errorf("AMF3_ReadValue return %+v", mixed)
+	}
+}
+
+func TestAMF3_EncodeMixedArray(t *testing.T) {
+	buf := new(bytes.Buffer)
+	arr := &MixedArray{
+		Dense:       []interface{}{"a", nil},
+		Associative: Object{"k": "v"},
+	}
+	_, err := AMF3_WriteValue(buf, arr)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x09, 0x05,
+		0x03, 'k', 0x06, 0x03, 'v',
+		0x01,
+		0x06, 0x03, 'a', 0x01,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, got)
+	}
+
+	value, err := AMF3_ReadValue(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	if !reflect.DeepEqual(value, arr) {
+		t.Errorf("AMF3_ReadValue return %+v, expect %+v", value, arr)
+	}
+}
+
+func TestAMF3_EncodeInterfaceArray(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, []interface{}{"a", true})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x09, 0x05, 0x01, 0x06, 0x03, 'a', 0x03}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, got)
+	}
+}
+
+func TestAMF3_EncodeDate(t *testing.T) {
+	date := time.Date(2013, 1, 2, 3, 4, 5, 678900000, time.UTC)
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, []*time.Time{&date, &date})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x09, 0x05, 0x01,
+		0x08, 0x01, 0x42, 0x73, 0xbf, 0x93, 0x73, 0xf2, 0xe0, 0x00, // 1357095845678
+		0x08, 0x02, // reference 1
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, got)
+	}
+}
+
+func TestAMF3_DecodeDate(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x05, 0x01,
+		0x08, 0x01, 0x42, 0x73, 0xbf, 0x93, 0x73, 0xf2, 0xe0, 0x00,
+		0x08, 0x02,
+	})
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	expect := time.Date(2013, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	arr, ok := got.([]interface{})
+	if !ok || len(arr) != 2 {
+		t.Fatalf("AMF3_ReadValue return %v", got)
+	}
+	for i, value := range arr {
+		if date, ok := value.(time.Time); !ok || !date.Equal(expect) || date.Location() != time.UTC {
+			t.Errorf("AMF3_ReadValue [%d] return %v, expect %v", i, value, expect)
+		}
+	}
+}
+
+func TestAMF3_DecodeVector(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		expect interface{}
+	}{
+		{[]byte{0x0d, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff}, []int32{1, -1}},
+		{[]byte{0x0e, 0x03, 0x01, 0xff, 0xff, 0xff, 0xff}, []uint32{0xffffffff}},
+		{[]byte{0x0f, 0x03, 0x00, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, []float64{1.5}},
+		{[]byte{0x10, 0x05, 0x01, 0x0d, 'S', 't', 'r', 'i', 'n', 'g', 0x06, 0x03, 'a', 0x01},
+			&ObjectVector{TypeName: "String", Fixed: true, Items: []interface{}{"a", nil}}},
+	}
+	for _, c := range cases {
+		got, err := AMF3_ReadValue(bytes.NewReader(c.data))
+		if err != nil {
+			t.Errorf("AMF3_ReadValue(% x) error: %s", c.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("AMF3_ReadValue(% x) return %#v, expect %#v", c.data, got, c.expect)
+		}
+	}
+}
+
+func TestAMF3_EncodeVector(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF3)
+	enc.Vectors = true
+	ints := []int32{1, -1}
+	err := enc.Encode([]interface{}{&ints, &ints, []float64{1.5}})
+	if err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	expect := []byte{0x09, 0x07, 0x01,
+		0x0d, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff,
+		0x0d, 0x02, // reference 1
+		0x0f, 0x03, 0x00, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("Encode expect % x got % x", expect, buf.Bytes())
+	}
+
+	vector := &ObjectVector{TypeName: "String", Items: []interface{}{"a"}}
+	data, err := Marshal(vector, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	expect = []byte{0x10, 0x03, 0x00, 0x0d, 'S', 't', 'r', 'i', 'n', 'g', 0x06, 0x03, 'a'}
+	if !bytes.Equal(expect, data) {
+		t.Errorf("Marshal expect % x got % x", expect, data)
+	}
+	var strs []string
+	if err = Unmarshal(data, &strs, AMF3); err != nil || len(strs) != 1 || strs[0] != "a" {
+		t.Errorf("Unmarshal return %v, %v", strs, err)
+	}
+}
+
+func TestAMF3_Dictionary(t *testing.T) {
+	key := Object{"k": "v"}
+	dict := &Dictionary{
+		WeakKeys: true,
+		Entries: []DictionaryEntry{
+			{Key: 1.5, Value: "number"},
+			{Key: "a", Value: true},
+			{Key: key, Value: nil},
+		},
+	}
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, dict)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x11, 0x07, 0x01,
+		0x05, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x0d, 'n', 'u', 'm', 'b', 'e', 'r',
+		0x06, 0x03, 'a', 0x03,
+		0x0a, 0x0b, 0x01, 0x03, 'k', 0x06, 0x03, 'v', 0x01, 0x01,
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, buf.Bytes())
+	}
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	if !reflect.DeepEqual(got, dict) {
+		t.Errorf("AMF3_ReadValue return %#v, expect %#v", got, dict)
+	}
+
+	data, _ := Marshal(&Dictionary{Entries: []DictionaryEntry{{Key: 1.0, Value: "one"}}}, AMF3)
+	var m map[int]string
+	if err = Unmarshal(data, &m, AMF3); err != nil || m[1] != "one" {
+		t.Errorf("Unmarshal return %v, %v", m, err)
+	}
+}
+
+func TestAMF3_EncodeInteger(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		expect []byte
+	}{
+		{0, []byte{0x04, 0x00}},
+		{int8(-1), []byte{0x04, 0xff, 0xff, 0xff, 0xff}},
+		{uint16(300), []byte{0x04, 0x82, 0x2c}},
+		{AMF3_INTEGER_MAX, []byte{0x04, 0xbf, 0xff, 0xff, 0xff}},
+		{AMF3_INTEGER_MIN, []byte{0x04, 0xc0, 0x80, 0x80, 0x00}},
+		{AMF3_INTEGER_MAX + 1, []byte{0x05, 0x41, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{uint32(0xffffffff), []byte{0x05, 0x41, 0xef, 0xff, 0xff, 0xff, 0xe0, 0x00, 0x00}},
+	}
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		n, err := AMF3_WriteValue(buf, c.v)
+		if err != nil {
+			t.Errorf("AMF3_WriteValue(%v) error: %s", c.v, err)
+			continue
+		}
+		if n != len(c.expect) || !bytes.Equal(c.expect, buf.Bytes()) {
+			t.Errorf("AMF3_WriteValue(%v) expect % x got % x (%d)", c.v, c.expect, buf.Bytes(), n)
+		}
+	}
+
+	buf := bytes.NewReader([]byte{0x04, 0xc0, 0x80, 0x80, 0x00})
+	if num, err := AMF3_ReadInteger(buf); err != nil || num != AMF3_INTEGER_MIN {
+		t.Errorf("AMF3_ReadInteger return %d, %v", num, err)
+	}
+}
+
+func TestAMF3_XML(t *testing.T) {
+	doc := XMLDocument("<a>1</a>")
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, []interface{}{&doc, &doc, XML("<b/>")})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x09, 0x07, 0x01,
+		0x07, 0x11, '<', 'a', '>', '1', '<', '/', 'a', '>',
+		0x07, 0x02, // reference 1
+		0x0b, 0x09, '<', 'b', '/', '>',
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, buf.Bytes())
+	}
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	if !reflect.DeepEqual(got, []interface{}{doc, doc, XML("<b/>")}) {
+		t.Errorf("AMF3_ReadValue return %#v", got)
+	}
+
+	var v struct {
+		Text string `xml:",chardata"`
+	}
+	if err = got.([]interface{})[0].(XMLDocument).Decode(&v); err != nil || v.Text != "1" {
+		t.Errorf("XMLDocument.Decode return %+v, %v", v, err)
+	}
+}
+
+func TestAMF3_OrderedObject(t *testing.T) {
+	var obj OrderedObject
+	obj.Set("z", "a")
+	obj.Set("a", "z")
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, &obj)
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x0a, 0x0b, 0x01,
+		0x03, 'z', 0x06, 0x03, 'a',
+		0x02, 0x06, 0x00, // string references to "a" and "z"
+		0x01,
+	}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, buf.Bytes())
+	}
+
+	dec := NewDecoder(buf, AMF3)
+	dec.OrderedObjects = true
+	value, err := dec.ReadValue()
+	if err != nil {
+		t.Fatalf("ReadValue error: %s", err)
+	}
+	if got, ok := value.(*OrderedObject); !ok || !reflect.DeepEqual(got, &obj) {
+		t.Errorf("ReadValue return %#v", value)
+	}
+}
+
+func TestAMF3_DecodeArrayCount(t *testing.T) {
+	// A count from the wire is not allocated before the items arrive
+	if _, err := AMF3_ReadValue(bytes.NewReader([]byte{0x09, 0xff, 0xff, 0xff, 0xff, 0x01})); err == nil {
+		t.Errorf("AMF3_ReadValue of truncated array expect error")
+	}
+
+	count := maxPrealloc*2 + 1
+	buf := bytes.NewBuffer([]byte{0x09})
+	AMF3_WriteU29(buf, uint32(count<<1|0x01))
+	buf.WriteByte(0x01)
+	for i := 0; i < count; i++ {
+		buf.WriteByte(0x03)
+	}
+	got, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	if arr, ok := got.([]interface{}); !ok || len(arr) != count || arr[count-1] != true {
+		t.Errorf("AMF3_ReadValue return %T of %d items", got, len(arr))
+	}
+
+	buf = bytes.NewBuffer([]byte{0x09})
+	AMF3_WriteU29(buf, uint32(count<<1|0x01))
+	buf.WriteByte(0x01)
+	for i := 0; i < count; i++ {
+		if i == 5 {
+			buf.Write([]byte{0x09, 0x00})
+		} else {
+			buf.WriteByte(0x03)
+		}
+	}
+	got, err = AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	arr, _ := got.([]interface{})
+	if self, ok := arr[5].([]interface{}); !ok || len(self) != count || &self[0] != &arr[0] {
+		t.Errorf("AMF3_ReadValue self reference is %T of %d items", arr[5], len(self))
+	}
+}
+
+func TestAMF3_DecodeVectorCount(t *testing.T) {
+	for _, marker := range []byte{0x0d, 0x0e, 0x0f, 0x10} {
+		data := []byte{marker, 0xff, 0xff, 0xff, 0xff, 0x00, 0x01}
+		if _, err := AMF3_ReadValue(bytes.NewReader(data)); err == nil {
+			t.Errorf("AMF3_ReadValue(% x) of truncated vector expect error", data)
+		}
+	}
+}
+
+func TestAMF3_DecodeDictionaryCount(t *testing.T) {
+	data := []byte{0x11, 0xff, 0xff, 0xff, 0xff, 0x00}
+	if _, err := AMF3_ReadValue(bytes.NewReader(data)); err == nil {
+		t.Errorf("AMF3_ReadValue of truncated dictionary expect error")
+	}
+}
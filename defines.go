@@ -3,7 +3,10 @@
 package amf
 
 import (
+	"encoding/xml"
 	"reflect"
+	"strings"
+	"time"
 )
 
 const (
@@ -36,6 +39,19 @@ const (
 	AMF0_MAX_STRING_LEN = 65535
 )
 
+// How the reserved time-zone of an AMF0 date is filled and interpreted.
+// The value is the offset from local time to UTC in minutes, as returned
+// by Date.getTimezoneOffset() in ActionScript.
+type TimeZoneMode int
+
+const (
+	// Write 0x0000 and ignore it on read, dates are returned in UTC.
+	TimeZoneIgnore TimeZoneMode = iota
+	// Write the offset of the date's location and return dates in a
+	// fixed zone with the offset read.
+	TimeZoneOffset
+)
+
 const (
 	AMF3_UNDEFINED_MARKER = 0x00
 	AMF3_NULL_MARKER      = 0x01
@@ -50,6 +66,12 @@ const (
 	AMF3_OBJECT_MARKER    = 0x0a
 	AMF3_XML_MARKER       = 0x0b
 	AMF3_BYTEARRAY_MARKER = 0x0c
+
+	AMF3_VECTOR_INT_MARKER    = 0x0d
+	AMF3_VECTOR_UINT_MARKER   = 0x0e
+	AMF3_VECTOR_DOUBLE_MARKER = 0x0f
+	AMF3_VECTOR_OBJECT_MARKER = 0x10
+	AMF3_DICTIONARY_MARKER    = 0x11
 )
 
 type Writer interface {
@@ -65,9 +87,301 @@ type Reader interface {
 // Undefined Type
 type Undefined struct{}
 
+var undefinedType = reflect.TypeOf(Undefined{})
+
 // Object Type
 type Object map[string]interface{}
 
+// Object with an ActionScript class name, the AMF0 typed object
+type TypedObject struct {
+	ClassName string
+	Object    Object
+}
+
+var typedObjectType = reflect.TypeOf(TypedObject{})
+
+// OrderedObject is an anonymous object that keeps its properties in the
+// order they were set or read, and is written in that order. The zero
+// value is an empty object.
+type OrderedObject struct {
+	keys   []string
+	values Object
+}
+
+var orderedObjectType = reflect.TypeOf(OrderedObject{})
+
+// Set sets the property key to value. A new key is added at the end, an
+// existing one keeps its place.
+func (obj *OrderedObject) Set(key string, value interface{}) {
+	if obj.values == nil {
+		obj.values = make(Object)
+	}
+	if _, ok := obj.values[key]; !ok {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.values[key] = value
+}
+
+// Get returns the value of the property key and whether it is set.
+func (obj *OrderedObject) Get(key string) (interface{}, bool) {
+	value, ok := obj.values[key]
+	return value, ok
+}
+
+// Delete removes the property key.
+func (obj *OrderedObject) Delete(key string) {
+	if _, ok := obj.values[key]; !ok {
+		return
+	}
+	delete(obj.values, key)
+	for i, k := range obj.keys {
+		if k == key {
+			obj.keys = append(obj.keys[:i], obj.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the property names in order.
+func (obj *OrderedObject) Keys() []string {
+	return obj.keys
+}
+
+// Len returns the number of properties.
+func (obj *OrderedObject) Len() int {
+	return len(obj.keys)
+}
+
+// orderedObjectOf returns the object of v, a value of type OrderedObject.
+func orderedObjectOf(v reflect.Value) *OrderedObject {
+	if v.CanAddr() && v.Addr().CanInterface() {
+		return v.Addr().Interface().(*OrderedObject)
+	}
+	obj := v.Interface().(OrderedObject)
+	return &obj
+}
+
+// AMF0 ECMA array, as ReadValue returns it. Keys are the property names in
+// the order they were sent and Count the associative-count, which is
+// written back as is.
+type ECMAArray struct {
+	Count  uint32
+	Keys   []string
+	Object Object
+}
+
+var ecmaArrayType = reflect.TypeOf(ECMAArray{})
+
+// StrictArray is written to AMF0 as a strict array, where other slices are
+// written as ECMA arrays.
+type StrictArray []interface{}
+
+var strictArrayType = reflect.TypeOf(StrictArray(nil))
+
+// AVMPlus wraps a value that is written to AMF0 in AMF3, after the
+// avmplus-object-marker.
+type AVMPlus struct {
+	Value interface{}
+}
+
+var avmPlusType = reflect.TypeOf(AVMPlus{})
+
+// Structs implementing ClassAliaser are written as typed objects of the
+// returned ActionScript class.
+type ClassAliaser interface {
+	ClassAlias() string
+}
+
+var classAliaserType = reflect.TypeOf((*ClassAliaser)(nil)).Elem()
+
+// structClassAlias returns the class name v is written with, or the empty
+// string for an anonymous object.
+func structClassAlias(v reflect.Value) string {
+	if !v.CanAddr() && v.CanInterface() && reflect.PtrTo(v.Type()).Implements(classAliaserType) {
+		// A struct passed by value, ClassAlias has a pointer receiver
+		v = addressable(v)
+	}
+	if i, ok := interfaceOf(v, classAliaserType); ok {
+		return i.(ClassAliaser).ClassAlias()
+	}
+	return typeAlias(v.Type())
+}
+
+// AMF0Marshaler is implemented by types that marshal themselves into
+// another value, which is written to AMF0 in their place.
+type AMF0Marshaler interface {
+	MarshalAMF0() (interface{}, error)
+}
+
+// AMF3Marshaler is the AMF3 counterpart of AMF0Marshaler.
+type AMF3Marshaler interface {
+	MarshalAMF3() (interface{}, error)
+}
+
+// AMF0Unmarshaler is implemented by types that unmarshal themselves from a
+// value as ReadValue returns it. It is used by Decoder and Unmarshal.
+type AMF0Unmarshaler interface {
+	UnmarshalAMF0(value interface{}) error
+}
+
+// AMF3Unmarshaler is the AMF3 counterpart of AMF0Unmarshaler.
+type AMF3Unmarshaler interface {
+	UnmarshalAMF3(value interface{}) error
+}
+
+var (
+	amf0MarshalerType   = reflect.TypeOf((*AMF0Marshaler)(nil)).Elem()
+	amf3MarshalerType   = reflect.TypeOf((*AMF3Marshaler)(nil)).Elem()
+	amf0UnmarshalerType = reflect.TypeOf((*AMF0Unmarshaler)(nil)).Elem()
+	amf3UnmarshalerType = reflect.TypeOf((*AMF3Unmarshaler)(nil)).Elem()
+)
+
+// interfaceOf returns v, or the address of v, as interface t if either
+// implements it. Nil pointers never do.
+func interfaceOf(v reflect.Value, t reflect.Type) (interface{}, bool) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	}
+	if v.Type().Implements(t) && v.CanInterface() {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(t) && v.Addr().CanInterface() {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+// Externalizable is implemented by types that read and write the body of
+// their AMF3 objects themselves, like IExternalizable in ActionScript. The
+// type is registered for its class with RegisterClassAlias.
+//
+// The decoder and encoder passed in share the reference tables of the
+// message, ReadValue and Encode read and write nested values while Read
+// and Write handle raw bytes.
+type Externalizable interface {
+	ReadExternal(dec *Decoder) error
+	WriteExternal(enc *Encoder) error
+}
+
+var externalizableType = reflect.TypeOf((*Externalizable)(nil)).Elem()
+
+// AMF3 traits, the class information shared by objects of the same type
+type AMF3Traits struct {
+	ClassName      string
+	Dynamic        bool
+	Externalizable bool
+	// Names of the sealed members
+	Members []string
+}
+
+func (traits *AMF3Traits) key() string {
+	flags := "s"
+	if traits.Externalizable {
+		flags = "e"
+	} else if traits.Dynamic {
+		flags = "d"
+	}
+	return flags + traits.ClassName + "\x00" + strings.Join(traits.Members, "\x00")
+}
+
+// Traits of an anonymous dynamic object
+var anonymousTraits = AMF3Traits{Dynamic: true}
+
+// AMF3 object with traits. Sealed holds the values of Traits.Members in
+// order, Dynamic holds the dynamic members if the traits are dynamic.
+type AMF3Object struct {
+	Traits  *AMF3Traits
+	Sealed  []interface{}
+	Dynamic Object
+}
+
+var amf3ObjectType = reflect.TypeOf(AMF3Object{})
+
+// AMF3 array with associative members. Dense holds the ordinal part,
+// Associative the string keyed part.
+type MixedArray struct {
+	Dense       []interface{}
+	Associative Object
+}
+
+var mixedArrayType = reflect.TypeOf(MixedArray{})
+
+// AMF3 Vector.<T> of objects. TypeName is the class of the items, empty
+// for Vector.<Object>, Fixed is set for a vector of fixed length.
+type ObjectVector struct {
+	TypeName string
+	Fixed    bool
+	Items    []interface{}
+}
+
+var objectVectorType = reflect.TypeOf(ObjectVector{})
+
+// Legacy flash.xml.XMLDocument, sent as an AMF3 XMLDocument or an AMF0 XML
+// document.
+type XMLDocument string
+
+var xmlDocumentType = reflect.TypeOf(XMLDocument(""))
+
+// Decode parses the document into v by encoding/xml.
+func (doc XMLDocument) Decode(v interface{}) error {
+	return xml.Unmarshal([]byte(doc), v)
+}
+
+// E4X XML, sent as an AMF3 XML.
+type XML string
+
+var xmlType = reflect.TypeOf(XML(""))
+
+// Decode parses the XML into v by encoding/xml.
+func (x XML) Decode(v interface{}) error {
+	return xml.Unmarshal([]byte(x), v)
+}
+
+// AMF3 flash.utils.Dictionary. Keys may be of any type, objects included,
+// so the entries are kept as a list in the order they are sent.
+type Dictionary struct {
+	WeakKeys bool
+	Entries  []DictionaryEntry
+}
+
+type DictionaryEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+var dictionaryType = reflect.TypeOf(Dictionary{})
+
+// Properties returns the sealed and dynamic members in a single Object.
+func (obj *AMF3Object) Properties() Object {
+	props := make(Object, len(obj.Sealed)+len(obj.Dynamic))
+	for key, value := range obj.Dynamic {
+		props[key] = value
+	}
+	for i, value := range obj.Sealed {
+		props[obj.Traits.Members[i]] = value
+	}
+	return props
+}
+
+// Identity of a written map, or of a value reached through a pointer.
+type objectKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// timeToMillis returns the milliseconds elapsed since the epoch, the
+// representation of both AMF0 and AMF3 dates.
+func timeToMillis(t time.Time) float64 {
+	return float64(t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond))
+}
+
+func millisToTime(ms float64) time.Time {
+	msec := int64(ms)
+	return time.Unix(msec/1000, (msec%1000)*int64(time.Millisecond)).UTC()
+}
+
 // stringValues is a slice of reflect.Value holding *reflect.StringValue.
 // It implements the methods to sort by string.
 type stringValues []reflect.Value
@@ -76,3 +390,140 @@ func (sv stringValues) Len() int           { return len(sv) }
 func (sv stringValues) Swap(i, j int)      { sv[i], sv[j] = sv[j], sv[i] }
 func (sv stringValues) Less(i, j int) bool { return sv.get(i) < sv.get(j) }
 func (sv stringValues) get(i int) string   { return sv[i].String() }
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Counts read from the wire are not trusted for allocation, a collection
+// starts with at most maxPrealloc items and grows as its items are read.
+const maxPrealloc = 1024
+
+func preallocLen(count int) int {
+	if count > maxPrealloc {
+		return maxPrealloc
+	}
+	return count
+}
+
+// A pendingArray stands in the reference table for an array of more than
+// maxPrealloc items while they are read, the slice holding them is not
+// final until the last one arrives. References to it are replaced with
+// the final slice once the array is complete.
+type pendingArray struct {
+	referenced bool
+}
+
+// resolveReference returns the value of a reference table entry.
+func resolveReference(v interface{}) interface{} {
+	if pending, ok := v.(*pendingArray); ok {
+		pending.referenced = true
+	}
+	return v
+}
+
+// replace returns v with every reference to p within it replaced by arr.
+func (p *pendingArray) replace(v interface{}, arr []interface{}, seen map[interface{}]bool) interface{} {
+	switch v := v.(type) {
+	case *pendingArray:
+		if v == p {
+			return arr
+		}
+	case []interface{}:
+		if len(v) == 0 || seen[&v[0]] {
+			break
+		}
+		seen[&v[0]] = true
+		for i := range v {
+			v[i] = p.replace(v[i], arr, seen)
+		}
+	case Object:
+		if v == nil || seen[reflect.ValueOf(v).Pointer()] {
+			break
+		}
+		seen[reflect.ValueOf(v).Pointer()] = true
+		for key, value := range v {
+			v[key] = p.replace(value, arr, seen)
+		}
+	case *MixedArray:
+		if seen[v] {
+			break
+		}
+		seen[v] = true
+		p.replace(v.Dense, arr, seen)
+		p.replace(v.Associative, arr, seen)
+	case *AMF3Object:
+		if seen[v] {
+			break
+		}
+		seen[v] = true
+		p.replace(v.Sealed, arr, seen)
+		p.replace(v.Dynamic, arr, seen)
+	case *TypedObject:
+		p.replace(v.Object, arr, seen)
+	case *ECMAArray:
+		p.replace(v.Object, arr, seen)
+	case *OrderedObject:
+		p.replace(v.values, arr, seen)
+	case *ObjectVector:
+		p.replace(v.Items, arr, seen)
+	case *Dictionary:
+		if seen[v] {
+			break
+		}
+		seen[v] = true
+		for i := range v.Entries {
+			v.Entries[i].Key = p.replace(v.Entries[i].Key, arr, seen)
+			v.Entries[i].Value = p.replace(v.Entries[i].Value, arr, seen)
+		}
+	}
+	return v
+}
+
+// readItems reads count values by read into a slice that grows as the
+// values arrive. publish is called with what the entry of the array in the
+// reference table holds: the slice, or a *pendingArray until the slice is
+// final when it may still be reallocated.
+func readItems(count int, read func() (interface{}, error), publish func(interface{})) ([]interface{}, error) {
+	if count <= maxPrealloc {
+		items := make([]interface{}, count)
+		publish(items)
+		for i := range items {
+			value, err := read()
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+	}
+	pending := &pendingArray{}
+	publish(pending)
+	items := make([]interface{}, 0, maxPrealloc)
+	for i := 0; i < count; i++ {
+		value, err := read()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	publish(items)
+	if pending.referenced {
+		pending.replace(items, items, make(map[interface{}]bool))
+	}
+	return items, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2013, zhangpeihao All rights reserved.
+
+package amf
+
+import (
+	"fmt"
+)
+
+// Class names of the Flex collections sent by BlazeDS and LCDS
+const (
+	ARRAY_COLLECTION_CLASS = "flex.messaging.io.ArrayCollection"
+	OBJECT_PROXY_CLASS     = "flex.messaging.io.ObjectProxy"
+)
+
+func init() {
+	RegisterClassAlias(ARRAY_COLLECTION_CLASS, ArrayCollection(nil))
+	RegisterClassAlias(OBJECT_PROXY_CLASS, ObjectProxy(nil))
+}
+
+// unwrapper is implemented by the built-in externalizable types, which
+// AMF3_ReadValue returns as the plain value they wrap.
+type unwrapper interface {
+	unwrap() interface{}
+}
+
+// ArrayCollection is a flex.messaging.io.ArrayCollection. It is read as
+// its []interface{} source, convert a slice to ArrayCollection to write it
+// as a collection instead of an array.
+type ArrayCollection []interface{}
+
+func (c *ArrayCollection) ReadExternal(dec *Decoder) error {
+	value, err := dec.ReadValue()
+	if err != nil {
+		return err
+	}
+	switch source := value.(type) {
+	case []interface{}:
+		*c = source
+	case *MixedArray:
+		*c = source.Dense
+	case nil:
+		*c = nil
+	default:
+		return fmt.Errorf("Type error: ArrayCollection source %T", value)
+	}
+	return nil
+}
+
+func (c *ArrayCollection) WriteExternal(enc *Encoder) error {
+	return enc.Encode([]interface{}(*c))
+}
+
+func (c *ArrayCollection) unwrap() interface{} {
+	return []interface{}(*c)
+}
+
+// ObjectProxy is a flex.messaging.io.ObjectProxy. It is read as the Object
+// it proxies, convert an Object to ObjectProxy to write it as a proxy.
+type ObjectProxy Object
+
+func (p *ObjectProxy) ReadExternal(dec *Decoder) error {
+	value, err := dec.ReadValue()
+	if err != nil {
+		return err
+	}
+	switch obj := value.(type) {
+	case Object:
+		*p = ObjectProxy(obj)
+	case *OrderedObject:
+		*p = ObjectProxy(obj.values)
+	case *AMF3Object:
+		*p = ObjectProxy(obj.Properties())
+	case nil:
+		*p = nil
+	default:
+		return fmt.Errorf("Type error: ObjectProxy object %T", value)
+	}
+	return nil
+}
+
+func (p *ObjectProxy) WriteExternal(enc *Encoder) error {
+	return enc.Encode(Object(*p))
+}
+
+func (p *ObjectProxy) unwrap() interface{} {
+	return Object(*p)
+}
@@ -0,0 +1,54 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestArrayCollection(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, ArrayCollection{"a", 1.5})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	expect := []byte{0x0A, 0x07, 0x43}
+	expect = append(expect, ARRAY_COLLECTION_CLASS...)
+	expect = append(expect, 0x09, 0x05, 0x01, 0x06, 0x03, 'a',
+		0x05, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("AMF3_WriteValue expect % x got % x", expect, buf.Bytes())
+	}
+	value, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	if !reflect.DeepEqual(value, []interface{}{"a", 1.5}) {
+		t.Errorf("AMF3_ReadValue return %#v", value)
+	}
+}
+
+func TestObjectProxy(t *testing.T) {
+	proxy := ObjectProxy{"name": "a"}
+	buf := new(bytes.Buffer)
+	_, err := AMF3_WriteValue(buf, []interface{}{proxy, &proxy, &proxy})
+	if err != nil {
+		t.Fatalf("AMF3_WriteValue error: %s", err)
+	}
+	value, err := AMF3_ReadValue(buf)
+	if err != nil {
+		t.Fatalf("AMF3_ReadValue error: %s", err)
+	}
+	arr, ok := value.([]interface{})
+	if !ok || len(arr) != 3 {
+		t.Fatalf("AMF3_ReadValue return %#v", value)
+	}
+	for i, item := range arr {
+		if !reflect.DeepEqual(item, Object{"name": "a"}) {
+			t.Errorf("AMF3_ReadValue item %d: %#v", i, item)
+		}
+	}
+	if reflect.ValueOf(arr[1]).Pointer() != reflect.ValueOf(arr[2]).Pointer() {
+		t.Errorf("AMF3_ReadValue reference did not return the same object")
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2013, zhangpeihao All rights reserved.
+
+package amf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Marshal returns the AMF0 or AMF3 encoding of v.
+func Marshal(v interface{}, version uint) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, version).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes one AMF0 or AMF3 value from data and stores it in the
+// value pointed to by v.
+//
+// Objects are stored into structs by the same `amf:"name,omitempty"` tags
+// WriteStruct uses, or into maps with string keys. Arrays are stored into
+// slices and arrays, dates into time.Time. Storing into an interface{}
+// keeps the value as ReadValue or AMF3_ReadValue returns it.
+func Unmarshal(data []byte, v interface{}, version uint) error {
+	return NewDecoder(bytes.NewReader(data), version).Decode(v)
+}
+
+// decodeState stores decoded values into Go values.
+type decodeState struct {
+	version uint
+
+	// Maps and pointers already made for a decoded object, so repeated and
+	// cyclic objects keep their shape.
+	seen map[objectKey]reflect.Value
+}
+
+// identity returns a key for the decoded object src, or false if src has
+// no identity.
+func identity(src interface{}) (uintptr, bool) {
+	switch s := src.(type) {
+	case Object:
+		return reflect.ValueOf(s).Pointer(), s != nil
+	case []interface{}:
+		if len(s) == 0 {
+			return 0, false
+		}
+		return reflect.ValueOf(&s[0]).Pointer(), true
+	case *TypedObject, *ECMAArray, *OrderedObject, *AMF3Object, *MixedArray, *ObjectVector, *Dictionary:
+		return reflect.ValueOf(s).Pointer(), true
+	}
+	return 0, false
+}
+
+func (d *decodeState) assign(src interface{}, dst reflect.Value) error {
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(reflect.ValueOf(src))
+		}
+		return nil
+	}
+	if _, ok := src.(Undefined); ok || src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr || dst.Kind() == reflect.Map {
+		if p, ok := identity(src); ok {
+			key := objectKey{dst.Type(), p}
+			if seen, ok := d.seen[key]; ok {
+				dst.Set(seen)
+				return nil
+			}
+			if dst.Kind() == reflect.Ptr {
+				if dst.IsNil() {
+					dst.Set(reflect.New(dst.Type().Elem()))
+				}
+				d.seen[key] = dst
+			}
+		}
+	}
+	if dst.Kind() == reflect.Ptr {
+		if sv := reflect.ValueOf(src); sv.Type().AssignableTo(dst.Type()) {
+			dst.Set(sv)
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return d.assign(src, dst.Elem())
+	}
+	if d.version == AMF3 {
+		if u, ok := interfaceOf(dst, amf3UnmarshalerType); ok {
+			return u.(AMF3Unmarshaler).UnmarshalAMF3(src)
+		}
+	} else if u, ok := interfaceOf(dst, amf0UnmarshalerType); ok {
+		return u.(AMF0Unmarshaler).UnmarshalAMF0(src)
+	}
+
+	if packageTypes[dst.Type()] {
+		return d.assignPackageType(src, dst)
+	}
+
+	switch s := src.(type) {
+	case bool:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(s)
+			return nil
+		}
+	case string:
+		if dst.Kind() == reflect.String {
+			dst.SetString(s)
+			return nil
+		}
+	case XMLDocument, XML:
+		if dst.Kind() == reflect.String {
+			dst.SetString(reflect.ValueOf(s).String())
+			return nil
+		}
+	case float64:
+		return assignNumber(s, dst)
+	case uint32:
+		return assignNumber(float64(s), dst)
+	case int32:
+		return assignNumber(float64(s), dst)
+	case time.Time:
+		if dst.Type() == timeType {
+			dst.Set(reflect.ValueOf(s))
+			return nil
+		}
+	case []byte:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(s)
+			return nil
+		}
+	case []interface{}:
+		return d.assignArray(s, dst)
+	case []int32, []uint32, []float64:
+		sv := reflect.ValueOf(s)
+		if sv.Type().AssignableTo(dst.Type()) {
+			dst.Set(sv)
+			return nil
+		}
+		arr := make([]interface{}, sv.Len())
+		for i := range arr {
+			arr[i] = sv.Index(i).Interface()
+		}
+		return d.assignArray(arr, dst)
+	case *ObjectVector:
+		return d.assignArray(s.Items, dst)
+	case *Dictionary:
+		if dst.Kind() == reflect.Map {
+			return d.assignDictionary(s, dst)
+		}
+	case *MixedArray:
+		if dst.Kind() == reflect.Map {
+			props := make(Object, len(s.Associative)+len(s.Dense))
+			for i, value := range s.Dense {
+				props[strconv.Itoa(i)] = value
+			}
+			for key, value := range s.Associative {
+				props[key] = value
+			}
+			return d.assignObject(props, src, dst)
+		}
+		return d.assignArray(s.Dense, dst)
+	case Object:
+		return d.assignObject(s, src, dst)
+	case *TypedObject:
+		return d.assignObject(s.Object, src, dst)
+	case *ECMAArray:
+		return d.assignObject(s.Object, src, dst)
+	case *OrderedObject:
+		return d.assignObject(s.values, src, dst)
+	case *AMF3Object:
+		return d.assignObject(s.Properties(), src, dst)
+	default:
+		// A value of a type registered with RegisterClassAlias
+		sv := reflect.ValueOf(src)
+		if sv.Kind() == reflect.Ptr && sv.Elem().Type().AssignableTo(dst.Type()) {
+			dst.Set(sv.Elem())
+			return nil
+		}
+	}
+	return fmt.Errorf("Type error: cannot unmarshal %T into %s", src, dst.Type())
+}
+
+// Struct types of the package that decoded values are stored into whole,
+// rather than by their fields.
+var packageTypes = map[reflect.Type]bool{
+	typedObjectType:   true,
+	ecmaArrayType:     true,
+	orderedObjectType: true,
+	amf3ObjectType:    true,
+	mixedArrayType:    true,
+	objectVectorType:  true,
+	dictionaryType:    true,
+}
+
+// assignPackageType stores src into dst of one of packageTypes, which
+// takes a decoded value of the same type. An OrderedObject also takes
+// the properties of an ECMA array in order, or of an Object by name.
+func (d *decodeState) assignPackageType(src interface{}, dst reflect.Value) error {
+	if dst.Type() == orderedObjectType {
+		var obj OrderedObject
+		switch s := src.(type) {
+		case *OrderedObject:
+			for _, key := range s.keys {
+				obj.Set(key, s.values[key])
+			}
+		case *ECMAArray:
+			for _, key := range s.Keys {
+				obj.Set(key, s.Object[key])
+			}
+		case Object:
+			var sv stringValues = reflect.ValueOf(s).MapKeys()
+			sort.Sort(sv)
+			for _, key := range sv {
+				obj.Set(key.String(), s[key.String()])
+			}
+		default:
+			return fmt.Errorf("Type error: cannot unmarshal %T into %s", src, dst.Type())
+		}
+		dst.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if sv := reflect.ValueOf(src); sv.Kind() == reflect.Ptr && sv.Elem().Type() == dst.Type() {
+		dst.Set(sv.Elem())
+		return nil
+	}
+	return fmt.Errorf("Type error: cannot unmarshal %T into %s", src, dst.Type())
+}
+
+func assignNumber(num float64, dst reflect.Value) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int64(num)
+		if float64(i) != num || dst.OverflowInt(i) {
+			return fmt.Errorf("Type error: cannot unmarshal number %v into %s", num, dst.Type())
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := uint64(num)
+		if num < 0 || float64(u) != num || dst.OverflowUint(u) {
+			return fmt.Errorf("Type error: cannot unmarshal number %v into %s", num, dst.Type())
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		dst.SetFloat(num)
+	default:
+		return fmt.Errorf("Type error: cannot unmarshal number into %s", dst.Type())
+	}
+	return nil
+}
+
+func (d *decodeState) assignArray(arr []interface{}, dst reflect.Value) error {
+	switch dst.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(dst.Type(), len(arr), len(arr))
+		for i, value := range arr {
+			if err := d.assign(value, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+	case reflect.Array:
+		if len(arr) > dst.Len() {
+			return fmt.Errorf("Type error: cannot unmarshal %d items into %s", len(arr), dst.Type())
+		}
+		for i := 0; i < dst.Len(); i++ {
+			var value interface{}
+			if i < len(arr) {
+				value = arr[i]
+			}
+			if err := d.assign(value, dst.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Type error: cannot unmarshal array into %s", dst.Type())
+}
+
+// assignObject stores the properties of the decoded object src.
+func (d *decodeState) assignObject(props Object, src interface{}, dst reflect.Value) error {
+	switch dst.Kind() {
+	case reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			break
+		}
+		m := reflect.MakeMap(dst.Type())
+		if p, ok := identity(src); ok {
+			d.seen[objectKey{dst.Type(), p}] = m
+		}
+		elemType := dst.Type().Elem()
+		for key, value := range props {
+			elem := reflect.New(elemType).Elem()
+			if err := d.assign(value, elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Struct:
+		fields := structFields(dst.Type())
+		for key, value := range props {
+			f := findField(fields, key)
+			if f == nil {
+				continue
+			}
+			field, ok := fieldByIndex(dst, f.index, true)
+			if !ok || !field.CanSet() {
+				// Unexported fields are written but never set.
+				continue
+			}
+			if err := d.assign(value, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		// ECMA arrays are objects with the indexes as names.
+		length := 0
+		for key := range props {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 {
+				return fmt.Errorf("Type error: cannot unmarshal object into %s", dst.Type())
+			}
+			if i >= length {
+				length = i + 1
+			}
+		}
+		arr := make([]interface{}, length)
+		for key, value := range props {
+			i, _ := strconv.Atoi(key)
+			arr[i] = value
+		}
+		return d.assignArray(arr, dst)
+	}
+	return fmt.Errorf("Type error: cannot unmarshal object into %s", dst.Type())
+}
+
+// assignDictionary stores the entries of dict into the map dst, the keys
+// are converted like the values.
+func (d *decodeState) assignDictionary(dict *Dictionary, dst reflect.Value) error {
+	m := reflect.MakeMap(dst.Type())
+	d.seen[objectKey{dst.Type(), reflect.ValueOf(dict).Pointer()}] = m
+	keyType, elemType := dst.Type().Key(), dst.Type().Elem()
+	for _, entry := range dict.Entries {
+		key := reflect.New(keyType).Elem()
+		if err := d.assign(entry.Key, key); err != nil {
+			return err
+		}
+		if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+			return fmt.Errorf("Type error: cannot unmarshal %T key into %s", entry.Key, dst.Type())
+		}
+		elem := reflect.New(elemType).Elem()
+		if err := d.assign(entry.Value, elem); err != nil {
+			return err
+		}
+		m.SetMapIndex(key, elem)
+	}
+	dst.Set(m)
+	return nil
+}
+
+//-----------------------------------------------------------------------
+// Struct fields
+
+// A struct field as written to and read from an object.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache struct {
+	sync.RWMutex
+	m map[reflect.Type][]structField
+}
+
+// structFields returns the fields of struct type t by the `amf` tags.
+// Fields of embedded structs are listed in place of the embedded field.
+func structFields(t reflect.Type) []structField {
+	fieldCache.RLock()
+	fields, ok := fieldCache.m[t]
+	fieldCache.RUnlock()
+	if ok {
+		return fields
+	}
+	fields = appendStructFields(nil, t, nil)
+	fieldCache.Lock()
+	if fieldCache.m == nil {
+		fieldCache.m = make(map[reflect.Type][]structField)
+	}
+	fieldCache.m[t] = fields
+	fieldCache.Unlock()
+	return fields
+}
+
+func appendStructFields(fields []structField, t reflect.Type, index []int) []structField {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+		if sf.Anonymous {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = appendStructFields(fields, ft, fieldIndex)
+				continue
+			}
+		}
+		tag := sf.Tag.Get("amf")
+		if tag == "-" {
+			continue
+		}
+		field := structField{name: sf.Name, index: fieldIndex}
+		if tag != "" {
+			options := strings.Split(tag, ",")
+			if options[0] != "" {
+				field.name = options[0]
+			}
+			for _, option := range options[1:] {
+				if option == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func findField(fields []structField, name string) *structField {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns the field of v at index. A nil embedded pointer is
+// allocated if alloc is set, otherwise the field is reported missing.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// writableField returns the field of struct v at index for writing. The
+// value of an unexported field is reached through its address, so it can
+// be written like any other; v must be addressable for that.
+func writableField(v reflect.Value, index []int) (reflect.Value, bool) {
+	field, ok := fieldByIndex(v, index, false)
+	if !ok || field.CanInterface() || !field.CanAddr() {
+		return field, ok
+	}
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem(), true
+}
+
+// addressable returns v, or a copy of v that is addressable.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
@@ -0,0 +1,284 @@
+package amf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type MarshalBase struct {
+	ID int `amf:"id"`
+}
+
+type MarshalItem struct {
+	Title string `amf:"title"`
+}
+
+type MarshalStruct struct {
+	MarshalBase
+	Name    string            `amf:"name"`
+	Note    string            `amf:"note,omitempty"`
+	Score   float64           `amf:"score"`
+	Active  bool              `amf:"active"`
+	Tags    []string          `amf:"tags"`
+	Item    *MarshalItem      `amf:"item"`
+	Items   []MarshalItem     `amf:"items"`
+	Attrs   map[string]string `amf:"attrs"`
+	Created time.Time         `amf:"created"`
+	Any     interface{}       `amf:"any"`
+	Skip    string            `amf:"-"`
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	in := MarshalStruct{
+		MarshalBase: MarshalBase{ID: 7},
+		Name:        "zhang",
+		Score:       1.5,
+		Active:      true,
+		Tags:        []string{"a", "b"},
+		Item:        &MarshalItem{"first"},
+		Items:       []MarshalItem{{"x"}, {"y"}},
+		Attrs:       map[string]string{"k": "v"},
+		Created:     time.Date(2013, 1, 2, 3, 4, 5, 678000000, time.UTC),
+		Any:         "any",
+		Skip:        "skip",
+	}
+	for _, version := range []uint{AMF0, AMF3} {
+		data, err := Marshal(&in, version)
+		if err != nil {
+			t.Fatalf("Marshal(AMF%d) error: %s", version, err)
+		}
+		var out MarshalStruct
+		err = Unmarshal(data, &out, version)
+		if err != nil {
+			t.Fatalf("Unmarshal(AMF%d) error: %s", version, err)
+		}
+		expect := in
+		expect.Skip = ""
+		if !reflect.DeepEqual(expect, out) {
+			t.Errorf("Unmarshal(AMF%d)\n   got: %+v\nexpect: %+v", version, out, expect)
+		}
+		if bytes.Contains(data, []byte("note")) {
+			t.Errorf("Marshal(AMF%d) wrote empty omitempty field", version)
+		}
+	}
+}
+
+type marshalNode struct {
+	Name string
+	Next *marshalNode
+}
+
+func TestUnmarshalCycle(t *testing.T) {
+	in := &marshalNode{Name: "a"}
+	in.Next = in
+	data, err := Marshal(in, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	var out *marshalNode
+	if err = Unmarshal(data, &out, AMF3); err != nil {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+	if out.Name != "a" || out.Next != out {
+		t.Errorf("Unmarshal return %+v", out)
+	}
+}
+
+func TestMarshalCycle(t *testing.T) {
+	// AMF0 has no references by default, a cycle cannot be written
+	node := &marshalNode{Name: "a"}
+	node.Next = node
+	m := map[string]interface{}{}
+	m["m"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	for _, in := range []interface{}{node, m, s} {
+		if _, err := Marshal(in, AMF0); err == nil {
+			t.Errorf("Marshal(%T) expect error", in)
+		}
+	}
+
+	// A value that appears twice without a cycle is written twice
+	shared := &marshalNode{Name: "b"}
+	in := []interface{}{shared, shared}
+	data, err := Marshal(in, AMF0)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	if bytes.Count(data, []byte("Name")) != 2 {
+		t.Errorf("Marshal return % x", data)
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	data, err := Marshal("foo", AMF0)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	var i int
+	if err = Unmarshal(data, &i, AMF0); err == nil {
+		t.Errorf("Unmarshal string into int expect error")
+	}
+	if err = Unmarshal(data, i, AMF0); err == nil {
+		t.Errorf("Unmarshal into non-pointer expect error")
+	}
+}
+
+type testCodec int
+
+const (
+	testCodecH264 testCodec = 7
+	testCodecAAC  testCodec = 10
+)
+
+var testCodecNames = map[testCodec]string{testCodecH264: "H264", testCodecAAC: "AAC"}
+
+func (c testCodec) MarshalAMF0() (interface{}, error) {
+	return testCodecNames[c], nil
+}
+
+func (c testCodec) MarshalAMF3() (interface{}, error) {
+	return testCodecNames[c], nil
+}
+
+func (c *testCodec) unmarshal(value interface{}) error {
+	for codec, name := range testCodecNames {
+		if name == value {
+			*c = codec
+			return nil
+		}
+	}
+	return errors.New("unknown codec")
+}
+
+func (c *testCodec) UnmarshalAMF0(value interface{}) error { return c.unmarshal(value) }
+func (c *testCodec) UnmarshalAMF3(value interface{}) error { return c.unmarshal(value) }
+
+type testStream struct {
+	Video testCodec `amf:"video"`
+	Audio testCodec `amf:"audio"`
+}
+
+func TestMarshaler(t *testing.T) {
+	in := testStream{Video: testCodecH264, Audio: testCodecAAC}
+	for _, version := range []uint{AMF0, AMF3} {
+		data, err := Marshal(in, version)
+		if err != nil {
+			t.Fatalf("Marshal(AMF%d) error: %s", version, err)
+		}
+		if !bytes.Contains(data, []byte("H264")) {
+			t.Errorf("Marshal(AMF%d) did not use MarshalAMF%d: % x", version, version, data)
+		}
+		var out testStream
+		if err = Unmarshal(data, &out, version); err != nil {
+			t.Fatalf("Unmarshal(AMF%d) error: %s", version, err)
+		}
+		if out != in {
+			t.Errorf("Unmarshal(AMF%d) return %+v", version, out)
+		}
+	}
+
+	data, _ := Marshal("VP6", AMF0)
+	var codec testCodec
+	if err := Unmarshal(data, &codec, AMF0); err == nil {
+		t.Errorf("Unmarshal expect error from UnmarshalAMF0")
+	}
+}
+
+type marshalPrivate struct {
+	Name    string
+	created time.Time
+	count   int
+}
+
+func TestMarshalUnexported(t *testing.T) {
+	in := marshalPrivate{Name: "a", created: time.Now(), count: 1}
+	for _, version := range []uint{AMF0, AMF3} {
+		data, err := Marshal(in, version)
+		if err != nil {
+			t.Fatalf("Marshal(AMF%d) error: %s", version, err)
+		}
+		// Unexported fields are written, but cannot be set when read back
+		if !bytes.Contains(data, []byte("created")) || !bytes.Contains(data, []byte("count")) {
+			t.Errorf("Marshal(AMF%d) skipped unexported fields: % x", version, data)
+		}
+		var out marshalPrivate
+		if err = Unmarshal(data, &out, version); err != nil {
+			t.Fatalf("Unmarshal(AMF%d) error: %s", version, err)
+		}
+		if out != (marshalPrivate{Name: "a"}) {
+			t.Errorf("Unmarshal(AMF%d) return %+v", version, out)
+		}
+	}
+}
+
+func TestMarshalByteArray(t *testing.T) {
+	in := struct {
+		A int
+		B [4]byte
+	}{1, [4]byte{1, 2, 3, 4}}
+	data, err := Marshal(in, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	if !bytes.Contains(data, []byte{0x0c, 0x09, 0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("Marshal return % x", data)
+	}
+
+	data, err = Marshal(in.B, AMF3)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+	if !bytes.Equal(data, []byte{0x0c, 0x09, 0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("Marshal return % x", data)
+	}
+}
+
+func TestUnmarshalPackageTypes(t *testing.T) {
+	var in OrderedObject
+	in.Set("z", "1")
+	in.Set("a", "2")
+	data, err := Marshal(&in, AMF0)
+	if err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(data), AMF0)
+	dec.OrderedObjects = true
+	var out OrderedObject
+	if err = dec.Decode(&out); err != nil {
+		t.Fatalf("Decode error: %s", err)
+	}
+	if !reflect.DeepEqual(out.Keys(), []string{"z", "a"}) {
+		t.Errorf("Decode keys %v", out.Keys())
+	}
+
+	// Without the option the properties come by name
+	out = OrderedObject{}
+	if err = Unmarshal(data, &out, AMF0); err != nil {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+	if !reflect.DeepEqual(out.Keys(), []string{"a", "z"}) {
+		t.Errorf("Unmarshal keys %v", out.Keys())
+	}
+
+	var arr ECMAArray
+	if err = Unmarshal(data, &arr, AMF0); err == nil {
+		t.Errorf("Unmarshal object into ECMAArray expect error")
+	}
+	var typed TypedObject
+	if err = Unmarshal(data, &typed, AMF0); err == nil {
+		t.Errorf("Unmarshal object into TypedObject expect error")
+	}
+
+	data = []byte{0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'k', 0x02, 0x00, 0x01, 'v', 0x00, 0x00, 0x09}
+	if err = Unmarshal(data, &arr, AMF0); err != nil {
+		t.Fatalf("Unmarshal error: %s", err)
+	}
+	if arr.Count != 1 || arr.Object["k"] != "v" {
+		t.Errorf("Unmarshal return %+v", arr)
+	}
+}
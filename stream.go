@@ -0,0 +1,208 @@
+// Copyright 2013, zhangpeihao All rights reserved.
+
+package amf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// An Encoder writes AMF0 or AMF3 values to an output stream.
+//
+// The reference tables of the encoder span all values encoded until Reset
+// is called, so a message made of several values is encoded by calling
+// Encode for each of them and Reset before the next message.
+//
+// Write and WriteByte write raw bytes, as WriteExternal of an Externalizable
+// does. They are flushed by the next Encode or Flush.
+type Encoder struct {
+	w       Writer
+	buf     *bufio.Writer
+	scratch bytes.Buffer // the value being encoded
+	version uint
+	amf0    *amf0Context
+	amf3    *amf3Context
+
+	// AMF0 only: send repeated pointers and maps as references.
+	References bool
+	// AMF0 only: how the time-zone of dates is filled.
+	TimeZone TimeZoneMode
+	// AMF0 only: switch to AVM+ and send objects, arrays, dates and XML in
+	// AMF3, for clients that negotiated objectEncoding 3.
+	AVMPlus bool
+	// AMF0 only: write slices and arrays as strict arrays instead of ECMA
+	// arrays.
+	StrictArrays bool
+	// AMF3 and AVM+ values: write []int32, []uint32 and []float64 as
+	// Vector.<int>, Vector.<uint> and Vector.<Number> instead of arrays.
+	Vectors bool
+}
+
+// NewEncoder returns a new encoder of the given version that writes to w.
+func NewEncoder(w io.Writer, version uint) *Encoder {
+	buf := bufio.NewWriter(w)
+	return &Encoder{
+		w:       buf,
+		buf:     buf,
+		version: version,
+		amf0:    newAMF0Context(),
+		amf3:    newAMF3Context(),
+	}
+}
+
+// Encode writes the encoding of v to the stream. If v fails to encode,
+// nothing of it is written and the reference tables are left as they were.
+func (enc *Encoder) Encode(v interface{}) error {
+	if enc.buf == nil {
+		// Within WriteExternal, the enclosing Encode holds the value back.
+		return enc.encode(enc.w, v)
+	}
+	enc.scratch.Reset()
+	if err := enc.encode(&enc.scratch, v); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write(enc.scratch.Bytes()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func (enc *Encoder) encode(w Writer, v interface{}) (err error) {
+	switch enc.version {
+	case AMF0:
+		enc.amf0.references = enc.References
+		enc.amf0.timeZone = enc.TimeZone
+		enc.amf0.avmPlus = enc.AVMPlus
+		enc.amf0.strict = enc.StrictArrays
+		enc.amf0.amf3.vectors = enc.Vectors
+		mark := enc.amf0.mark()
+		if _, err = enc.amf0.writeValue(w, v); err != nil {
+			enc.amf0.rollback(mark)
+		}
+	case AMF3:
+		enc.amf3.vectors = enc.Vectors
+		mark := enc.amf3.mark()
+		if _, err = enc.amf3.writeValue(w, v); err != nil {
+			enc.amf3.rollback(mark)
+		}
+	default:
+		err = errors.New("Unsupported version")
+	}
+	return
+}
+
+// Write writes p to the stream as is.
+func (enc *Encoder) Write(p []byte) (int, error) {
+	return enc.w.Write(p)
+}
+
+// WriteByte writes the byte c to the stream as is.
+func (enc *Encoder) WriteByte(c byte) error {
+	return enc.w.WriteByte(c)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (enc *Encoder) Flush() error {
+	if enc.buf == nil {
+		return nil
+	}
+	return enc.buf.Flush()
+}
+
+// Reset clears the reference tables at a message boundary.
+func (enc *Encoder) Reset() {
+	enc.amf0 = newAMF0Context()
+	enc.amf3 = newAMF3Context()
+}
+
+// A Decoder reads AMF0 or AMF3 values from an input stream.
+//
+// Like the Encoder, the reference tables of the decoder span all values
+// decoded until Reset is called. For AMF0 that includes the AMF3 tables of
+// the values following an avmplus-object-marker.
+//
+// Read and ReadByte read raw bytes, as ReadExternal of an Externalizable
+// does.
+type Decoder struct {
+	r       Reader
+	version uint
+	amf0    *amf0Context
+	amf3    *amf3Context
+
+	// AMF0 only: how the time-zone of dates is interpreted.
+	TimeZone TimeZoneMode
+	// Return anonymous objects as *OrderedObject instead of Object from
+	// ReadValue.
+	OrderedObjects bool
+}
+
+// NewDecoder returns a new decoder of the given version that reads from r.
+// If r does not implement ReadByte it is buffered, and the decoder may read
+// data from r beyond the values requested.
+func NewDecoder(r io.Reader, version uint) *Decoder {
+	br, ok := r.(Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{
+		r:       br,
+		version: version,
+		amf0:    newAMF0Context(),
+		amf3:    newAMF3Context(),
+	}
+}
+
+// Decode reads the next value from the stream and stores it in the value
+// pointed to by v, as described for Unmarshal. A value implementing
+// AMF0Unmarshaler or AMF3Unmarshaler, for the version of the decoder,
+// unmarshals itself.
+func (dec *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Decode needs a non-nil pointer")
+	}
+	value, err := dec.ReadValue()
+	if err != nil {
+		return err
+	}
+	d := &decodeState{
+		version: dec.version,
+		seen:    make(map[objectKey]reflect.Value),
+	}
+	return d.assign(value, rv.Elem())
+}
+
+// ReadValue reads the next value from the stream as ReadValue or
+// AMF3_ReadValue returns it.
+func (dec *Decoder) ReadValue() (interface{}, error) {
+	switch dec.version {
+	case AMF0:
+		dec.amf0.timeZone = dec.TimeZone
+		dec.amf0.ordered = dec.OrderedObjects
+		dec.amf0.amf3.ordered = dec.OrderedObjects
+		return dec.amf0.readValue(dec.r)
+	case AMF3:
+		dec.amf3.ordered = dec.OrderedObjects
+		return dec.amf3.readValue(dec.r)
+	}
+	return nil, errors.New("Unsupported version")
+}
+
+// Read reads exactly len(p) bytes from the stream.
+func (dec *Decoder) Read(p []byte) (int, error) {
+	return io.ReadFull(dec.r, p)
+}
+
+// ReadByte reads a single byte from the stream.
+func (dec *Decoder) ReadByte() (byte, error) {
+	return dec.r.ReadByte()
+}
+
+// Reset clears the reference tables at a message boundary.
+func (dec *Decoder) Reset() {
+	dec.amf0 = newAMF0Context()
+	dec.amf3 = newAMF3Context()
+}
@@ -0,0 +1,185 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoderReferences(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF3)
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode("foo"); err != nil {
+			t.Fatalf("Encode error: %s", err)
+		}
+	}
+	enc.Reset()
+	if err := enc.Encode("foo"); err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	expect := []byte{
+		0x06, 0x07, 'f', 'o', 'o',
+		0x06, 0x00, // reference to the first "foo"
+		0x06, 0x07, 'f', 'o', 'o', // tables cleared by Reset
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("Encoder expect % x got % x", expect, got)
+	}
+
+	dec := NewDecoder(bytes.NewReader(got), AMF3)
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			dec.Reset()
+		}
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			t.Fatalf("Decode [%d] error: %s", i, err)
+		}
+		if s != "foo" {
+			t.Errorf("Decode [%d] return %s", i, s)
+		}
+	}
+}
+
+func TestEncoderAMF0(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF0)
+	enc.References = true
+	obj := Object{"a": "b"}
+	for _, value := range []interface{}{"connect", 1, obj, obj} {
+		if err := enc.Encode(value); err != nil {
+			t.Fatalf("Encode error: %s", err)
+		}
+	}
+	expect := []byte{
+		0x02, 0x00, 0x07, 'c', 'o', 'n', 'n', 'e', 'c', 't',
+		0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x03, 0x00, 0x01, 'a', 0x02, 0x00, 0x01, 'b', 0x00, 0x00, 0x09,
+		0x07, 0x00, 0x00,
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("Encoder expect % x got % x", expect, got)
+	}
+
+	// A plain io.Reader is buffered by the decoder.
+	dec := NewDecoder(struct{ *bytes.Reader }{bytes.NewReader(got)}, AMF0)
+	var name string
+	var id int
+	var first, second Object
+	for _, v := range []interface{}{&name, &id, &first, &second} {
+		if err := dec.Decode(v); err != nil {
+			t.Fatalf("Decode error: %s", err)
+		}
+	}
+	if name != "connect" || id != 1 || first["a"] != "b" || second["a"] != "b" {
+		t.Errorf("Decode return %s %d %v %v", name, id, first, second)
+	}
+}
+
+func TestEncoderAVMPlus(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF0)
+	enc.AVMPlus = true
+	obj := Object{"a": "b"}
+	for _, value := range []interface{}{"_result", 1, obj, obj} {
+		if err := enc.Encode(value); err != nil {
+			t.Fatalf("Encode error: %s", err)
+		}
+	}
+	expect := []byte{
+		0x02, 0x00, 0x07, '_', 'r', 'e', 's', 'u', 'l', 't',
+		0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x11, 0x0a, 0x0b, 0x01, 0x03, 'a', 0x06, 0x03, 'b', 0x01,
+		0x11, 0x0a, 0x00, // AMF3 reference 0
+	}
+	if got := buf.Bytes(); !bytes.Equal(expect, got) {
+		t.Errorf("Encoder expect % x got % x", expect, got)
+	}
+
+	buf.Reset()
+	_, err := WriteValue(buf, []interface{}{"a", AVMPlus{"a"}})
+	if err != nil {
+		t.Fatalf("WriteValue error: %s", err)
+	}
+	expect = []byte{0x08, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x01, '0', 0x02, 0x00, 0x01, 'a',
+		0x00, 0x01, '1', 0x11, 0x06, 0x03, 'a',
+		0x00, 0x00, 0x09,
+	}
+	if got := buf.Bytes(); !bytes.Equal(expect, got) {
+		t.Errorf("WriteValue expect % x got % x", expect, got)
+	}
+}
+
+func TestDecoderAVMPlus(t *testing.T) {
+	data := []byte{
+		0x11, 0x06, 0x07, 'f', 'o', 'o',
+		0x11, 0x06, 0x00, // AMF3 reference to "foo" across the switch
+		0x11, 0x06, 0x00, // read after Reset
+	}
+	dec := NewDecoder(bytes.NewReader(data), AMF0)
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			t.Fatalf("Decode [%d] error: %s", i, err)
+		}
+		if s != "foo" {
+			t.Errorf("Decode [%d] return %s", i, s)
+		}
+	}
+	dec.Reset()
+	var s string
+	if err := dec.Decode(&s); err == nil {
+		t.Errorf("Decode after Reset expect reference error, return %s", s)
+	}
+}
+
+func TestEncoderError(t *testing.T) {
+	bad := map[string]interface{}{"a": "x", "b": make(chan int)}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF0)
+	if err := enc.Encode(bad); err == nil {
+		t.Fatalf("Encode expect error")
+	}
+	if err := enc.Encode("ok"); err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	expect := []byte{0x02, 0x00, 0x02, 'o', 'k'}
+	if got := buf.Bytes(); !bytes.Equal(expect, got) {
+		t.Errorf("Encoder after error expect % x got % x", expect, got)
+	}
+
+	// The strings of the failed value are not in the table
+	buf.Reset()
+	enc = NewEncoder(buf, AMF3)
+	if err := enc.Encode(bad); err == nil {
+		t.Fatalf("Encode expect error")
+	}
+	if err := enc.Encode("x"); err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	expect = []byte{0x06, 0x03, 'x'}
+	if got := buf.Bytes(); !bytes.Equal(expect, got) {
+		t.Errorf("Encoder after error expect % x got % x", expect, got)
+	}
+}
+
+func TestEncoderAVMPlusVectors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf, AMF0)
+	enc.AVMPlus = true
+	enc.Vectors = true
+	if err := enc.Encode([]int32{1, 2}); err != nil {
+		t.Fatalf("Encode error: %s", err)
+	}
+	expect := []byte{0x11, 0x0d, 0x05, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+	}
+	if got := buf.Bytes(); !bytes.Equal(expect, got) {
+		t.Errorf("Encoder expect % x got % x", expect, got)
+	}
+}